@@ -0,0 +1,223 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testHeaderSize = 0xD0
+
+// testFile describes a file to be stored by testArchive.build.
+type testFile struct {
+	name  string
+	data  []byte
+	flags uint32
+
+	locale   uint16
+	platform uint16
+}
+
+// testArchive builds small v4 archives that use the hash and block tables.
+type testArchive struct {
+	files     []testFile
+	blockSize uint16
+
+	// noListfile leaves the (listfile) out of the archive.
+	noListfile bool
+}
+
+func (a *testArchive) add(name string, data []byte, flags uint32) *testArchive {
+	a.files = append(a.files, testFile{name: name, data: data, flags: flags})
+	return a
+}
+
+func (a *testArchive) build(t testing.TB) []byte {
+	files := append([]testFile(nil), a.files...)
+
+	if !a.noListfile {
+		files = append(files, testListfile(files))
+	}
+
+	hashTableSize := 16
+	for hashTableSize < len(files)*2 {
+		hashTableSize <<= 1
+	}
+
+	buffer := make([]byte, testHeaderSize)
+	hashTable := make([]byte, hashTableSize*hashTableEntrySize)
+	for i := range hashTable {
+		hashTable[i] = 0xFF
+	}
+	blockTable := make([]byte, len(files)*16)
+
+	for i, file := range files {
+		position := len(buffer)
+		stored := a.store(t, file)
+		buffer = append(buffer, stored...)
+
+		block := blockTable[i*16:]
+		if len(file.data) != 0 {
+			binary.LittleEndian.PutUint32(block[0:4], uint32(position))
+		}
+		binary.LittleEndian.PutUint32(block[4:8], uint32(len(stored)))
+		binary.LittleEndian.PutUint32(block[8:12], uint32(len(file.data)))
+		binary.LittleEndian.PutUint32(block[12:16], file.flags)
+
+		slot := int(blizz(file.name, blizzHashTableIndex)) & (hashTableSize - 1)
+		for binary.LittleEndian.Uint32(hashTable[slot*hashTableEntrySize+12:]) != hashTableEmpty {
+			slot = (slot + 1) & (hashTableSize - 1)
+		}
+		entry := hashTable[slot*hashTableEntrySize:]
+		binary.LittleEndian.PutUint32(entry[0:4], blizz(file.name, blizzHashNameA))
+		binary.LittleEndian.PutUint32(entry[4:8], blizz(file.name, blizzHashNameB))
+		binary.LittleEndian.PutUint16(entry[8:10], file.locale)
+		binary.LittleEndian.PutUint16(entry[10:12], file.platform)
+		binary.LittleEndian.PutUint32(entry[12:16], uint32(i))
+	}
+
+	encryptBlock(hashTable, cryptKeyHashTable)
+	encryptBlock(blockTable, cryptKeyBlockTable)
+
+	hashTablePos := len(buffer)
+	buffer = append(buffer, hashTable...)
+	blockTablePos := len(buffer)
+	buffer = append(buffer, blockTable...)
+
+	header := buffer[:testHeaderSize]
+	copy(header, headerMPQ)
+	header[3] = headerArchive
+	binary.LittleEndian.PutUint32(header[4:8], testHeaderSize)
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(buffer)))
+	binary.LittleEndian.PutUint16(header[12:14], mpqFormatVersion4)
+	binary.LittleEndian.PutUint16(header[14:16], a.blockSize)
+	binary.LittleEndian.PutUint32(header[16:20], uint32(hashTablePos))
+	binary.LittleEndian.PutUint32(header[20:24], uint32(blockTablePos))
+	binary.LittleEndian.PutUint32(header[24:28], uint32(hashTableSize))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(len(files)))
+	binary.LittleEndian.PutUint64(header[44:52], uint64(len(buffer)))
+	binary.LittleEndian.PutUint64(header[68:76], uint64(len(hashTable)))
+	binary.LittleEndian.PutUint64(header[76:84], uint64(len(blockTable)))
+
+	return buffer
+}
+
+// testListfile is the (listfile) build stores for files.
+func testListfile(files []testFile) testFile {
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		names = append(names, file.name)
+	}
+	sort.Strings(names)
+	return testFile{name: "(listfile)", data: []byte(strings.Join(names, "\r\n")), flags: fileFlagExists | fileFlagCompress | fileFlagSingleUnit}
+}
+
+// store returns the bytes of a file as they would be written to the archive.
+func (a *testArchive) store(t testing.TB, file testFile) []byte {
+	if file.flags&fileFlagPatchFile != 0 {
+		info := make([]byte, patchInfoSize)
+		binary.LittleEndian.PutUint32(info[0:4], patchInfoSize)
+		binary.LittleEndian.PutUint32(info[4:8], 0x80000000)
+		binary.LittleEndian.PutUint32(info[8:12], uint32(len(file.data)))
+		sum := md5.Sum(file.data)
+		copy(info[12:], sum[:])
+
+		file.flags &^= fileFlagPatchFile
+		return append(info, a.store(t, file)...)
+	}
+
+	if file.flags&fileFlagCompress == 0 {
+		return append([]byte(nil), file.data...)
+	}
+
+	if file.flags&fileFlagSingleUnit != 0 {
+		return compressSector(t, file.data)
+	}
+
+	sectorSize := 512 << a.blockSize
+	var sectors [][]byte
+	for i := 0; i < len(file.data); i += sectorSize {
+		end := i + sectorSize
+		if end > len(file.data) {
+			end = len(file.data)
+		}
+		sectors = append(sectors, compressSector(t, file.data[i:end]))
+	}
+
+	count := len(sectors) + 1
+	if file.flags&fileFlagSectorCRC != 0 {
+		count++
+	}
+
+	stored := make([]byte, count*4)
+	for i, sector := range sectors {
+		binary.LittleEndian.PutUint32(stored[i*4:], uint32(len(stored)))
+		stored = append(stored, sector...)
+	}
+	binary.LittleEndian.PutUint32(stored[len(sectors)*4:], uint32(len(stored)))
+
+	if file.flags&fileFlagSectorCRC != 0 {
+		for _, sector := range sectors {
+			var checksum [4]byte
+			binary.LittleEndian.PutUint32(checksum[:], sectorChecksum(sector))
+			stored = append(stored, checksum[:]...)
+		}
+		binary.LittleEndian.PutUint32(stored[(count-1)*4:], uint32(len(stored)))
+	}
+
+	return stored
+}
+
+// compressSector zlib compresses a sector unless that would not make it smaller.
+func compressSector(t testing.TB, data []byte) []byte {
+	buffer := &bytes.Buffer{}
+	buffer.WriteByte(compressionZlib)
+	writer := zlib.NewWriter(buffer)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() >= len(data) {
+		return append([]byte(nil), data...)
+	}
+	return buffer.Bytes()
+}
+
+// encryptBlock is the inverse of decryptBlock.
+func encryptBlock(block []byte, key1 uint32) {
+	var key2 uint32 = 0xEEEEEEEE
+
+	for i := 0; i+4 <= len(block); i += 4 {
+		key2 += cryptTable[0x400+(key1&0xFF)]
+
+		value := binary.LittleEndian.Uint32(block[i:])
+		binary.LittleEndian.PutUint32(block[i:], value^(key1+key2))
+
+		key1 = ((^key1 << 0x15) + 0x11111111) | (key1 >> 0x0B)
+		key2 = value + key2 + (key2 << 5) + 3
+	}
+}
+
+// testData returns length bytes of compressible but not repetitive data.
+func testData(length int) []byte {
+	data := make([]byte, length)
+	for i := range data {
+		data[i] = byte(i/7) ^ byte(i%13)
+	}
+	return data
+}
+
+func openTestArchive(t testing.TB, archive []byte) *MPQ {
+	mpq, err := OpenReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal("Could not open test archive:", err)
+	}
+	return mpq
+}
@@ -0,0 +1,166 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	attributesVersion1 = 100
+
+	attributeCRC32    = 0x00000001 // The file has CRC32 values
+	attributeFileTime = 0x00000002 // The file has FILETIME values
+	attributeMD5      = 0x00000004 // The file has MD5 values
+	attributePatchBit = 0x00000008 // The file has a patch bit array
+
+	attributesHeaderSize = 8
+
+	// fileTimeEpochDiff is the amount of 100ns intervals between
+	// 1601-01-01 (the FILETIME epoch) and 1970-01-01 (the unix epoch).
+	fileTimeEpochDiff = 116444736000000000
+)
+
+// Attributes is the parsed contents of the (attributes) file. Each array
+// is indexed by the block (or BET) table index of the file it describes
+// and is nil if the archive did not store it.
+type Attributes struct {
+	Version int
+	Flags   uint32
+
+	CRC32     []uint32
+	FileTime  []uint64
+	MD5       [][]byte
+	PatchBits []bool
+}
+
+func (m *MPQ) readAttributes() error {
+	info, err := m.FileInfo("(attributes)")
+	if err != nil {
+		return err
+	}
+
+	reader, err := m.open(info)
+	if err != nil {
+		return err
+	}
+
+	buffer, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	attributes, err := parseAttributes(buffer, m.blockCount())
+	if err != nil {
+		return err
+	}
+
+	m.Attributes = attributes
+	return nil
+}
+
+// parseAttributes decodes an (attributes) file describing blockCount entries.
+// Some archives were written with one or two entries less than there are blocks
+// (the (attributes) and (signature) files did not describe themselves) so those
+// are accepted as well.
+func parseAttributes(buffer []byte, blockCount int) (*Attributes, error) {
+	if len(buffer) < attributesHeaderSize {
+		return nil, corruptError("Attributes ended unexpectedly")
+	}
+
+	attributes := &Attributes{
+		Version: int(binary.LittleEndian.Uint32(buffer[0:4])),
+		Flags:   binary.LittleEndian.Uint32(buffer[4:8]),
+	}
+
+	if attributes.Version != attributesVersion1 {
+		return nil, unsupportedError("Attributes version not supported")
+	}
+
+	count := -1
+	for _, c := range []int{blockCount, blockCount - 1, blockCount - 2} {
+		if c >= 0 && attributesSize(attributes.Flags, c) <= len(buffer) {
+			count = c
+			break
+		}
+	}
+	if count < 0 {
+		return nil, corruptError("Attributes ended unexpectedly")
+	}
+
+	offset := attributesHeaderSize
+	if attributes.Flags&attributeCRC32 != 0 {
+		attributes.CRC32 = make([]uint32, count)
+		for i := 0; i < count; i++ {
+			attributes.CRC32[i] = binary.LittleEndian.Uint32(buffer[offset : offset+4])
+			offset += 4
+		}
+	}
+
+	if attributes.Flags&attributeFileTime != 0 {
+		attributes.FileTime = make([]uint64, count)
+		for i := 0; i < count; i++ {
+			attributes.FileTime[i] = binary.LittleEndian.Uint64(buffer[offset : offset+8])
+			offset += 8
+		}
+	}
+
+	if attributes.Flags&attributeMD5 != 0 {
+		attributes.MD5 = make([][]byte, count)
+		for i := 0; i < count; i++ {
+			attributes.MD5[i] = make([]byte, digestSize)
+			copy(attributes.MD5[i], buffer[offset:offset+digestSize])
+			offset += digestSize
+		}
+	}
+
+	if attributes.Flags&attributePatchBit != 0 {
+		attributes.PatchBits = make([]bool, count)
+		for i := 0; i < count; i++ {
+			attributes.PatchBits[i] = buffer[offset+i/8]&(0x80>>uint(i%8)) != 0
+		}
+	}
+
+	return attributes, nil
+}
+
+// attributesSize calculates the size of an (attributes) file with count entries.
+func attributesSize(flags uint32, count int) int {
+	size := attributesHeaderSize
+	if flags&attributeCRC32 != 0 {
+		size += count * 4
+	}
+	if flags&attributeFileTime != 0 {
+		size += count * 8
+	}
+	if flags&attributeMD5 != 0 {
+		size += count * digestSize
+	}
+	if flags&attributePatchBit != 0 {
+		size += (count + 7) / 8
+	}
+	return size
+}
+
+// apply copies the attributes for the block at index onto the file.
+func (a *Attributes) apply(file *File, index int) {
+	if index < len(a.CRC32) {
+		file.CRC32 = a.CRC32[index]
+	}
+	if index < len(a.FileTime) && a.FileTime[index] != 0 {
+		file.ModTime = fileTimeToTime(a.FileTime[index])
+	}
+	// Storm leaves the digest zeroed for files it did not hash.
+	if index < len(a.MD5) && !isZero(a.MD5[index]) {
+		file.MD5 = a.MD5[index]
+	}
+	if index < len(a.PatchBits) && a.PatchBits[index] {
+		file.IsPatch = true
+	}
+}
+
+// fileTimeToTime converts a windows FILETIME to a time.Time.
+func fileTimeToTime(fileTime uint64) time.Time {
+	nsec := (int64(fileTime) - fileTimeEpochDiff) * 100
+	return time.Unix(0, nsec).UTC()
+}
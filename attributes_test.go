@@ -0,0 +1,169 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// addAttributes adds the (listfile) and an (attributes) file with the CRC32s
+// and MD5s of all files to a test archive.
+func addAttributes(archive *testArchive) *testArchive {
+	archive.noListfile = true
+	archive.files = append(archive.files, testListfile(archive.files))
+
+	count := len(archive.files) + 1
+	attributes := make([]byte, attributesSize(attributeCRC32|attributeMD5, count))
+	binary.LittleEndian.PutUint32(attributes[0:4], attributesVersion1)
+	binary.LittleEndian.PutUint32(attributes[4:8], attributeCRC32|attributeMD5)
+	for i, file := range archive.files {
+		binary.LittleEndian.PutUint32(attributes[8+i*4:], crc32.ChecksumIEEE(file.data))
+		sum := md5.Sum(file.data)
+		copy(attributes[8+count*4+i*digestSize:], sum[:])
+	}
+	return archive.add("(attributes)", attributes, fileFlagExists)
+}
+
+func TestAttributes(t *testing.T) {
+	setup()
+
+	if m.Attributes == nil {
+		t.Fatal("Attributes were not read.")
+	}
+	if m.Attributes.Version != attributesVersion1 {
+		t.Errorf("Incorrect Value for Version: %d", m.Attributes.Version)
+	}
+	if m.Attributes.Flags != attributeCRC32|attributeMD5 {
+		t.Errorf("Incorrect Value for Flags: %X", m.Attributes.Flags)
+	}
+	if len(m.Attributes.CRC32) != 14 {
+		t.Errorf("Incorrect Length for CRC32: %d", len(m.Attributes.CRC32))
+	}
+	if len(m.Attributes.MD5) != 14 {
+		t.Errorf("Incorrect Length for MD5: %d", len(m.Attributes.MD5))
+	}
+	if m.Attributes.FileTime != nil {
+		t.Error("There should be no file times.")
+	}
+	if m.Attributes.PatchBits != nil {
+		t.Error("There should be no patch bits.")
+	}
+}
+
+func TestAttributes_File(t *testing.T) {
+	setup()
+
+	for _, name := range []string{"replay.details", "replay.initData", "(listfile)"} {
+		file, err := m.FileInfo(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := m.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if crc := crc32.ChecksumIEEE(contents); crc != file.CRC32 {
+			t.Errorf("%s> CRC32 wrong: %08X, expected: %08X", name, file.CRC32, crc)
+		}
+		if name == "(listfile)" {
+			if file.MD5 != nil {
+				t.Errorf("%s> MD5 should not be set: % 02X", name, file.MD5)
+			}
+		} else if sum := md5.Sum(contents); !bytes.Equal(sum[:], file.MD5) {
+			t.Errorf("%s> MD5 wrong: % 02X, expected: % 02X", name, file.MD5, sum)
+		}
+		if !file.ModTime.IsZero() {
+			t.Errorf("%s> ModTime should not be set: %v", name, file.ModTime)
+		}
+		if file.IsPatch {
+			t.Errorf("%s> Should not be a patch", name)
+		}
+	}
+}
+
+func TestAttributes_Parse(t *testing.T) {
+	digest := bytes.Repeat([]byte{0xAB}, digestSize)
+
+	buffer := make([]byte, attributesSize(attributeMD5|attributePatchBit, 9))
+	binary.LittleEndian.PutUint32(buffer[0:4], attributesVersion1)
+	binary.LittleEndian.PutUint32(buffer[4:8], attributeMD5|attributePatchBit)
+	copy(buffer[8+16*3:], digest)
+	buffer[8+16*9] = 0x10
+	buffer[8+16*9+1] = 0x80
+
+	attributes, err := parseAttributes(buffer, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attributes.MD5) != 9 {
+		t.Fatalf("Wrong number of entries: %d", len(attributes.MD5))
+	}
+	if !bytes.Equal(attributes.MD5[3], digest) {
+		t.Errorf("Wrong MD5: % 02X", attributes.MD5[3])
+	}
+	for i, patch := range attributes.PatchBits {
+		if patch != (i == 3 || i == 8) {
+			t.Errorf("%d> Wrong patch bit: %v", i, patch)
+		}
+	}
+
+	file := &File{}
+	attributes.apply(file, 8)
+	if !file.IsPatch {
+		t.Error("File should be a patch.")
+	}
+
+	if _, err = parseAttributes(buffer[:20], 10); err == nil {
+		t.Error("Expected an error for a short attributes file.")
+	}
+}
+
+func TestAttributes_FileTime(t *testing.T) {
+	got := fileTimeToTime(130896000000000000)
+	expected := time.Date(2015, time.October, 18, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(expected) {
+		t.Errorf("Wrong time: %v, expected: %v", got, expected)
+	}
+}
+
+func TestOpen_BadAttributes(t *testing.T) {
+	attributes := make([]byte, attributesHeaderSize)
+	binary.LittleEndian.PutUint32(attributes[0:4], 99)
+	data := (&testArchive{}).
+		add("file", testData(100), fileFlagExists).
+		add("(attributes)", attributes, fileFlagExists).
+		build(t)
+
+	mpq, err := OpenBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mpq.Attributes != nil || !errors.Is(mpq.AttributesError, ErrUnsupported) {
+		t.Error("Expected no attributes and an unsupported error, got:", mpq.AttributesError)
+	}
+	if _, err = mpq.readAll("file"); err != nil {
+		t.Error(err)
+	}
+
+	if mpq, err = OpenBytes(data, Lenient()); err != nil {
+		t.Error(err)
+	} else if warnings := mpq.Warnings(); len(warnings) != 1 || warnings[0].Code != WarnAttributes {
+		t.Error("Wrong warnings:", warnings)
+	}
+
+	if _, err = OpenBytes(data, Strict()); err == nil {
+		t.Error("Expected an error with Strict.")
+	}
+}
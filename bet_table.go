@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"io"
 
 	"github.com/aarondl/bitstream"
 )
@@ -13,7 +12,7 @@ import (
 var (
 	headerBETTable = []byte("BET\x1A")
 
-	errorBETTableBounds = errors.New("BET Table ended unexpectedly")
+	errorBETTableBounds = corruptError("BET Table ended unexpectedly")
 )
 
 // BETTable from the MPQ Header.
@@ -52,24 +51,18 @@ type BETTable struct {
 	entries []BETTableEntry
 }
 
-func (m *MPQ) readBETTable(r io.Reader) error {
+func (m *MPQ) readBETTable(position int64) error {
 	bet := &BETTable{}
 
-	header := make([]byte, extTableHeaderSize)
-	if _, err := r.Read(header); err != nil {
-		return err
-	}
-
-	if bytes.Compare(headerBETTable, header[:4]) != 0 {
-		return fmt.Errorf("BET Table header not found, got: %02X", header[:4])
-	}
-	bet.Version = int(binary.LittleEndian.Uint32(header[4:8]))
-	bet.DataSize = int(binary.LittleEndian.Uint32(header[8:12]))
-
-	buffer, err := decryptDecompressExtTable(r, uint64(bet.DataSize), m.Header.BETTableSize64, cryptKeyBlockTable)
+	var buffer []byte
+	var err error
+	bet.Version, bet.DataSize, buffer, err = m.readExtTable("BET Table", position, headerBETTable, m.Header.BETTableSize64, cryptKeyBlockTable)
 	if err != nil {
 		return err
 	}
+	if len(buffer) < 76 {
+		return errorBETTableBounds
+	}
 
 	bet.TableSize = int(binary.LittleEndian.Uint32(buffer[0:4]))
 	bet.EntryCount = int(binary.LittleEndian.Uint32(buffer[4:8]))
@@ -91,18 +84,47 @@ func (m *MPQ) readBETTable(r io.Reader) error {
 	bet.HashArraySize = int(binary.LittleEndian.Uint32(buffer[68:72]))
 	bet.FlagCount = int(binary.LittleEndian.Uint32(buffer[72:76]))
 
+	limits := m.limits()
+	if err = limits.checkEntries("BET Table", bet.EntryCount); err != nil {
+		return err
+	}
+	if err = limits.checkEntries("BET Table flags", bet.FlagCount); err != nil {
+		return err
+	}
+	for _, bits := range []int{bet.BitCountFilePos, bet.BitCountFileSize, bet.BitCountCmpSize, bet.BitCountFlagIndex, bet.HashSizeTotal} {
+		if bits < 0 || bits > 64 {
+			return corruptError(fmt.Sprintf("BET Table bit count is invalid: %d", bits))
+		}
+	}
+	if bet.TableEntrySize < 0 || bet.TableEntrySize > 64*5 {
+		return corruptError(fmt.Sprintf("BET Table entry size is invalid: %d", bet.TableEntrySize))
+	}
+
 	offset := 76
+	if len(buffer) < offset+bet.FlagCount*4 {
+		return errorBETTableBounds
+	}
 	bet.Flags = make([]uint32, bet.FlagCount)
 	for i := 0; i < bet.FlagCount; i++ {
 		bet.Flags[i] = binary.LittleEndian.Uint32(buffer[offset : offset+4])
 		offset += 4
 	}
 
-	bet.TableEntries = make([]byte, (bet.TableEntrySize*bet.EntryCount+7)/8)
+	// The sizes of the arrays are checked before they are allocated.
+	entriesSize := (uint64(bet.TableEntrySize)*uint64(bet.EntryCount) + 7) / 8
+	hashesSize := (uint64(bet.HashSizeTotal)*uint64(bet.EntryCount) + 7) / 8
+	if err = limits.checkTable("BET Table", entriesSize+hashesSize, entriesSize+hashesSize); err != nil {
+		return err
+	}
+	if uint64(len(buffer)-offset) < entriesSize+hashesSize {
+		return errorBETTableBounds
+	}
+
+	bet.TableEntries = make([]byte, entriesSize)
+	bet.Hashes = make([]byte, hashesSize)
+
 	copy(bet.TableEntries, buffer[offset:offset+len(bet.TableEntries)])
 	offset += len(bet.TableEntries)
-
-	bet.Hashes = make([]byte, (bet.HashSizeTotal*bet.EntryCount+7)/8)
 	copy(bet.Hashes, buffer[offset:offset+len(bet.Hashes)])
 
 	m.BETTable = bet
@@ -152,6 +174,9 @@ func (b *BETTable) Entries() ([]BETTableEntry, error) {
 		}
 		entry.FlagIndex = uint32(val)
 
+		if val >= uint64(len(b.Flags)) {
+			return nil, corruptError("BET Table flag index out of range")
+		}
 		entry.Flags = b.Flags[entry.FlagIndex]
 	}
 
@@ -167,3 +192,57 @@ func (b *BETTable) Entries() ([]BETTableEntry, error) {
 	b.entries = entries
 	return entries, nil
 }
+
+// Entry decodes entry i of the table without decoding the other entries.
+func (b *BETTable) Entry(i int) (BETTableEntry, error) {
+	var entry BETTableEntry
+	if i < 0 || i >= b.EntryCount {
+		return entry, errors.New("BET Table index out of range")
+	}
+
+	offset := i * b.TableEntrySize
+	fields := []struct {
+		value       *uint64
+		index, size int
+	}{
+		{&entry.FilePosition, b.BitIndexFilePos, b.BitCountFilePos},
+		{&entry.FileSize, b.BitIndexFileSize, b.BitCountFileSize},
+		{&entry.CompressedSize, b.BitIndexCmpSize, b.BitCountCmpSize},
+	}
+
+	var err error
+	for _, field := range fields {
+		if *field.value, err = readBits(b.TableEntries, offset+field.index, field.size); err != nil {
+			return entry, errorBETTableBounds
+		}
+	}
+
+	flagIndex, err := readBits(b.TableEntries, offset+b.BitIndexFlagIndex, b.BitCountFlagIndex)
+	if err != nil {
+		return entry, errorBETTableBounds
+	}
+	if flagIndex >= uint64(len(b.Flags)) {
+		return entry, corruptError("BET Table flag index out of range")
+	}
+	entry.FlagIndex = uint32(flagIndex)
+	entry.Flags = b.Flags[flagIndex]
+
+	if entry.NameHash2, err = b.NameHash2(i); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// NameHash2 reads the name hash of entry i.
+func (b *BETTable) NameHash2(i int) (uint64, error) {
+	if i < 0 || i >= b.EntryCount {
+		return 0, errors.New("BET Table index out of range")
+	}
+
+	val, err := readBits(b.Hashes, i*b.HashSizeTotal, b.HashSizeTotal)
+	if err != nil {
+		return 0, errorBETTableBounds
+	}
+	return val, nil
+}
@@ -2,9 +2,10 @@ package mpq
 
 import (
 	"encoding/binary"
-	"io"
 )
 
+const blockTableEntrySize = 16
+
 // BlockTable is the older style BETTable in the MPQ Header.
 type BlockTable struct {
 	EntryCount int
@@ -21,18 +22,22 @@ type BlockTableEntry struct {
 	Flags          uint32
 }
 
-func (m *MPQ) readBlockTable(r io.Reader) error {
-	b := &BlockTable{EntryCount: m.Header.BlockTableSize}
-
-	size := uint64(m.Header.BlockTableSize)
-	compressedSize := m.Header.BlockTableSize64
+func (m *MPQ) readBlockTable(position int64) error {
+	count, compressedSize := m.Header.BlockTableSize, m.Header.BlockTableSize64
+	recovering := m.recovering() && storedTable(count, blockTableEntrySize, compressedSize)
+	if recovering {
+		count, compressedSize = m.recoverTableSize(position, count, blockTableEntrySize), 0
+	}
 
-	var err error
-	if b.Table, err = decryptDecompressTable(r, size, compressedSize, cryptKeyBlockTable); err != nil {
+	table, err := m.readTable("Block table", position, count, blockTableEntrySize, compressedSize, cryptKeyBlockTable)
+	if err != nil {
 		return err
 	}
 
-	m.BlockTable = b
+	m.BlockTable = &BlockTable{EntryCount: count, Table: table}
+	if recovering {
+		m.recoverBlockTable()
+	}
 	return nil
 }
 
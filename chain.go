@@ -0,0 +1,215 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ArchiveSet searches a base archive and its patch archives as if they were
+// one archive. Files are looked up in the archive with the highest priority
+// first, so a patch archive is added with a higher priority than the archive
+// it patches. A deletion marker in a higher priority archive hides the file
+// in all archives of lower priority.
+//
+// The zero value is an empty set ready to use.
+type ArchiveSet struct {
+	archives []*chainArchive
+}
+
+type chainArchive struct {
+	mpq      *MPQ
+	priority int
+	prefix   string
+}
+
+// name is the name a file is stored under in this archive.
+func (c *chainArchive) name(name string) string {
+	return c.prefix + name
+}
+
+// Add an archive to the set. Of archives with the same priority the one
+// added last is searched first.
+func (a *ArchiveSet) Add(m *MPQ, priority int) {
+	a.AddPatch(m, priority, "")
+}
+
+// AddPatch adds a patch archive that stores its files under prefix, such as
+// base\ or enUS\. Files are looked up in it by their name with the prefix
+// in front, DetectPatchPrefix can be used to find the prefix.
+func (a *ArchiveSet) AddPatch(m *MPQ, priority int, prefix string) {
+	if prefix != "" && !strings.HasSuffix(prefix, `\`) {
+		prefix += `\`
+	}
+	archive := &chainArchive{mpq: m, priority: priority, prefix: prefix}
+
+	i := sort.Search(len(a.archives), func(i int) bool {
+		return a.archives[i].priority <= priority
+	})
+
+	a.archives = append(a.archives, nil)
+	copy(a.archives[i+1:], a.archives[i:])
+	a.archives[i] = archive
+}
+
+// Archives returns the archives in the order they are searched.
+func (a *ArchiveSet) Archives() []*MPQ {
+	archives := make([]*MPQ, len(a.archives))
+	for i, archive := range a.archives {
+		archives[i] = archive.mpq
+	}
+	return archives
+}
+
+// Lookup finds the archive that provides a file. It returns ErrFileDeleted if
+// the file was removed by a deletion marker and ErrFileNotFound if no archive
+// has it.
+//
+// If the file is a patch, the File describes the patched file: FileSize and MD5
+// are those of the result of the patches, taken from the header of the patch,
+// while Position, CompressedSize and Flags still describe the stored patch.
+func (a *ArchiveSet) Lookup(name string) (*MPQ, *File, error) {
+	for _, archive := range a.archives {
+		file, err := archive.mpq.FileInfo(archive.name(name))
+		if err == ErrFileNotFound {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if file.IsDeletionMarker {
+			return nil, nil, ErrFileDeleted
+		}
+		if file.Flags&fileFlagPatchFile != 0 {
+			patch, err := archive.mpq.readPatchHeader(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			file.FileSize = uint64(patch.SizeAfter)
+			file.MD5 = patch.MD5After
+		}
+		return archive.mpq, file, nil
+	}
+
+	return nil, nil, ErrFileNotFound
+}
+
+// FileInfo gets the file information for a filename from the archive with the
+// highest priority that has it.
+func (a *ArchiveSet) FileInfo(name string) (*File, error) {
+	_, file, err := a.Lookup(name)
+	return file, err
+}
+
+// Open the file from the archive with the highest priority that has it. If
+// that is a patch file the patches are applied, from the lowest priority up,
+// to the file they patch and the patched contents are returned.
+func (a *ArchiveSet) Open(name string) (io.Reader, error) {
+	var patches []*Patch
+
+	for _, archive := range a.archives {
+		file, err := archive.mpq.FileInfo(archive.name(name))
+		if err == ErrFileNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if file.IsDeletionMarker {
+			if len(patches) != 0 {
+				return nil, ErrPatchNoBase
+			}
+			return nil, ErrFileDeleted
+		}
+
+		if file.Flags&fileFlagPatchFile != 0 {
+			patch, err := archive.mpq.readPatch(file)
+			if err != nil {
+				return nil, err
+			}
+			patches = append(patches, patch)
+			continue
+		}
+
+		if len(patches) == 0 {
+			return archive.mpq.open(file)
+		}
+
+		contents, err := archive.mpq.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := len(patches) - 1; i >= 0; i-- {
+			if contents, err = patches[i].Apply(contents); err != nil {
+				return nil, err
+			}
+		}
+		return bytes.NewReader(contents), nil
+	}
+
+	if len(patches) != 0 {
+		return nil, ErrPatchNoBase
+	}
+	return nil, ErrFileNotFound
+}
+
+// Files lists the files of all archives in the set, leaving out the files
+// that were removed by a deletion marker. The prefix of patch archives is
+// removed from the names of their files.
+func (a *ArchiveSet) Files() ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+
+	for _, archive := range a.archives {
+		names, err := archive.mpq.Files()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			if archive.prefix != "" {
+				if !strings.HasPrefix(name, archive.prefix) {
+					continue
+				}
+				name = name[len(archive.prefix):]
+			}
+
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			if !a.deleted(name) {
+				files = append(files, name)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// deleted is true if the first archive that has an entry for name has a
+// deletion marker. Entries that can not be read are passed over, the data of
+// patch files is not read.
+func (a *ArchiveSet) deleted(name string) bool {
+	for _, archive := range a.archives {
+		if file, err := archive.mpq.FileInfo(archive.name(name)); err == nil {
+			return file.IsDeletionMarker
+		}
+	}
+	return false
+}
+
+// Close closes every archive in the set and returns the first error.
+func (a *ArchiveSet) Close() error {
+	var err error
+	for _, archive := range a.archives {
+		if closeErr := archive.mpq.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package mpq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func testArchiveSet(t *testing.T) *ArchiveSet {
+	base := (&testArchive{}).
+		add("base", []byte("base"), fileFlagExists).
+		add("patched", []byte("old"), fileFlagExists).
+		add("deleted", []byte("deleted"), fileFlagExists).
+		build(t)
+
+	patch := (&testArchive{}).
+		add("patched", []byte("new"), fileFlagExists).
+		add("added", []byte("added"), fileFlagExists).
+		add("deleted", nil, fileFlagExists|fileFlagDelete).
+		build(t)
+
+	set := &ArchiveSet{}
+	set.Add(openTestArchive(t, patch), 1)
+	set.Add(openTestArchive(t, base), 0)
+	return set
+}
+
+func TestArchiveSet_Open(t *testing.T) {
+	set := testArchiveSet(t)
+
+	for name, expected := range map[string]string{
+		"base":    "base",
+		"patched": "new",
+		"added":   "added",
+	} {
+		reader, err := set.Open(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(contents, []byte(expected)) {
+			t.Errorf("%s> Wrong contents: %s", name, contents)
+		}
+	}
+
+	if _, err := set.Open("deleted"); err != ErrFileDeleted {
+		t.Error("Expected ErrFileDeleted, got:", err)
+	}
+	if _, err := set.FileInfo("missing"); err != ErrFileNotFound {
+		t.Error("Expected ErrFileNotFound, got:", err)
+	}
+
+	archives := set.Archives()
+	m, _, err := set.Lookup("base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != archives[1] {
+		t.Error("Base should come from the last archive.")
+	}
+}
+
+func TestArchiveSet_Files(t *testing.T) {
+	files, err := testArchiveSet(t).Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"(listfile)", "added", "base", "patched"}
+	if len(files) != len(expected) {
+		t.Fatal("Wrong files:", files)
+	}
+	for i, name := range expected {
+		if files[i] != name {
+			t.Errorf("%d> Expected: %s, got: %s", i, name, files[i])
+		}
+	}
+}
+
+func TestArchiveSet_Priority(t *testing.T) {
+	set := &ArchiveSet{}
+	a, b, c := &MPQ{}, &MPQ{}, &MPQ{}
+	set.Add(a, 0)
+	set.Add(b, 2)
+	set.Add(c, 0)
+
+	archives := set.Archives()
+	if archives[0] != b || archives[1] != c || archives[2] != a {
+		t.Error("Archives are in the wrong order.")
+	}
+}
+
+func TestArchiveSet_PatchPrefix(t *testing.T) {
+	metadata := []byte("BaseBuild=13164\r\nPatchBuild=13205\r\n")
+	base := (&testArchive{}).
+		add(`dir\file`, []byte("old"), fileFlagExists).
+		add("other", []byte("other"), fileFlagExists).
+		build(t)
+	localeBase := (&testArchive{}).
+		add("enUS-md5.lst", []byte("md5"), fileFlagExists).
+		build(t)
+	patch := (&testArchive{}).
+		add(`base\dir\file`, []byte("new"), fileFlagExists).
+		add(`base\(patch_metadata)`, metadata, fileFlagExists).
+		add("other", []byte("unprefixed"), fileFlagExists).
+		build(t)
+	locale := (&testArchive{}).
+		add(`enUS\added`, []byte("added"), fileFlagExists).
+		add(`base\(patch_metadata)`, metadata, fileFlagExists).
+		build(t)
+
+	baseMPQ := openTestArchive(t, base)
+	localeBaseMPQ := openTestArchive(t, localeBase)
+	patchMPQ := openTestArchive(t, patch)
+	localeMPQ := openTestArchive(t, locale)
+
+	if prefix := DetectPatchPrefix(baseMPQ, patchMPQ); prefix != `base\` {
+		t.Errorf("Wrong prefix: %q", prefix)
+	}
+	if prefix := DetectPatchPrefix(localeBaseMPQ, localeMPQ); prefix != `enUS\` {
+		t.Errorf("Wrong prefix: %q", prefix)
+	}
+
+	set := &ArchiveSet{}
+	set.Add(baseMPQ, 0)
+	set.Add(localeBaseMPQ, 0)
+	set.AddPatch(patchMPQ, 1, "base")
+	set.AddPatch(localeMPQ, 2, DetectPatchPrefix(localeBaseMPQ, localeMPQ))
+
+	for name, expected := range map[string]string{
+		`dir\file`: "new",
+		"other":    "other",
+		"added":    "added",
+	} {
+		reader, err := set.Open(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(contents, []byte(expected)) {
+			t.Errorf("%s> Wrong contents: %s", name, contents)
+		}
+	}
+
+	files, err := set.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"(listfile)", "(patch_metadata)", "added", `dir\file`, "enUS-md5.lst", "other"}
+	if strings.Join(files, ",") != strings.Join(expected, ",") {
+		t.Errorf("Wrong files: %v", files)
+	}
+}
+
+func TestDetectPatchPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   []string
+		patch  []string
+		prefix string
+	}{
+		{"Base", []string{"file"}, []string{`base\file`, `base\(patch_metadata)`}, `base\`},
+		{"Locale", []string{"deDE-md5.lst"}, []string{`deDE\file`, `base\(patch_metadata)`}, `deDE\`},
+		{"NotALocale", []string{"patch-md5.lst"}, []string{`base\file`, `base\(patch_metadata)`}, `base\`},
+		{"NoMetadata", []string{"enUS-md5.lst"}, []string{`base\file`, `enUS\file`}, ""},
+		{"RootMetadata", []string{"file"}, []string{`base\file`, "(patch_metadata)"}, ""},
+	}
+
+	build := func(files []string) *MPQ {
+		archive := &testArchive{}
+		for _, name := range files {
+			archive.add(name, []byte(name), fileFlagExists)
+		}
+		return openTestArchive(t, archive.build(t))
+	}
+
+	for _, test := range tests {
+		if prefix := DetectPatchPrefix(build(test.base), build(test.patch)); prefix != test.prefix {
+			t.Errorf("%s: Wrong prefix: %q", test.name, prefix)
+		}
+	}
+}
+
+func TestPatchMetadata(t *testing.T) {
+	data := []byte("BaseBuild=13164\r\nPatchBuild = 13205\r\nnot a value\r\n")
+	mpq := openTestArchive(t, (&testArchive{}).
+		add(`base\(patch_metadata)`, data, fileFlagExists|fileFlagCompress).
+		build(t))
+
+	for _, prefix := range []string{"base", `base\`} {
+		metadata, err := mpq.PatchMetadata(prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(metadata.Data, data) {
+			t.Errorf("%s: Wrong data: %q", prefix, metadata.Data)
+		}
+		if len(metadata.Values) != 2 || metadata.Values["BaseBuild"] != "13164" || metadata.Values["PatchBuild"] != "13205" {
+			t.Errorf("%s: Wrong values: %v", prefix, metadata.Values)
+		}
+	}
+
+	if _, err := mpq.PatchMetadata(""); err != ErrFileNotFound {
+		t.Error("Expected ErrFileNotFound, got:", err)
+	}
+}
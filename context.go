@@ -0,0 +1,150 @@
+package mpq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// OpenContext opens an MPQ file for reading like Open, but stops between
+// reading the tables of the archive once ctx is done. The error is then
+// ctx.Err() wrapped with the file name.
+func OpenContext(ctx context.Context, filename string, opts ...Option) (*MPQ, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := openReader(ctx, f, opts)
+	if err != nil {
+		f.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		return nil, withArchive(err, filename)
+	}
+
+	m.name = filename
+	return m, nil
+}
+
+// OpenFileContext opens a file of the archive like Open. The returned reader
+// fails with ctx.Err(), wrapped with the file name, once ctx is done. Files
+// stored in sectors check ctx before each sector is decoded.
+func (m *MPQ) OpenFileContext(ctx context.Context, name string) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	file, ok := m.FileList[name]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	return m.openContext(ctx, file)
+}
+
+func (m *MPQ) openContext(ctx context.Context, file *File) (io.Reader, error) {
+	reader, err := m.open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if sectors, ok := reader.(*sectorReader); ok {
+		sectors.ctx = ctx
+	}
+	return &contextReader{ctx: ctx, reader: reader, name: file.Name}, nil
+}
+
+// ExtractContext writes the contents of a file of the archive to w and
+// returns the amount of bytes written. It stops once ctx is done.
+func (m *MPQ) ExtractContext(ctx context.Context, name string, w io.Writer) (int64, error) {
+	reader, err := m.OpenFileContext(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+
+	return io.Copy(w, reader)
+}
+
+// ExtractAllContext writes every file of the archive to dir, creating
+// directories for the parts of the names separated by backslashes. Deletion
+// markers are skipped. It stops between and during files once ctx is done.
+func (m *MPQ) ExtractAllContext(ctx context.Context, dir string) error {
+	files, err := m.Files()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range files {
+		if err = ctx.Err(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+
+		if file := m.FileList[name]; file.IsDeletionMarker {
+			continue
+		}
+
+		if err = m.extractFile(ctx, name, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MPQ) extractFile(ctx context.Context, name, dir string) error {
+	path := filepath.Join(dir, filepath.FromSlash(strings.Replace(name, `\`, "/", -1)))
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s: File name leaves the target directory", name)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.ExtractContext(ctx, name, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// contextReader stops reading from the underlying reader once ctx is done.
+// If name is set the context's error is wrapped with it.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+	name   string
+}
+
+func (c *contextReader) Read(buf []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, c.wrap(err)
+	}
+
+	n, err := c.reader.Read(buf)
+	if err != nil && err == c.ctx.Err() {
+		err = c.wrap(err)
+	}
+	return n, err
+}
+
+func (c *contextReader) wrap(err error) error {
+	if c.name == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", c.name, err)
+}
@@ -0,0 +1,138 @@
+package mpq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOpenContext(t *testing.T) {
+	const name = "Garden of Terror (72).StormReplay"
+
+	mpq, err := OpenContext(context.Background(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mpq.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = OpenContext(ctx, name)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected context.Canceled, got:", err)
+	}
+	if !strings.Contains(err.Error(), name) {
+		t.Error("Expected the error to name the file:", err)
+	}
+}
+
+func TestMPQ_OpenFileContext(t *testing.T) {
+	data := testData(5000)
+	archive := (&testArchive{}).
+		add("sectors", data, fileFlagExists|fileFlagCompress).
+		add("single", data, fileFlagExists|fileFlagCompress|fileFlagSingleUnit).
+		build(t)
+	mpq := openTestArchive(t, archive)
+
+	for _, name := range []string{"sectors", "single"} {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		reader, err := mpq.OpenFileContext(ctx, name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		buffer := make([]byte, 100)
+		if _, err = reader.Read(buffer); err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(buffer, data[:100]) {
+			t.Errorf("%s> Wrong contents.", name)
+		}
+
+		cancel()
+		_, err = ioutil.ReadAll(reader)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("%s> Expected context.Canceled, got: %v", name, err)
+		} else if !strings.HasPrefix(err.Error(), name+":") {
+			t.Errorf("%s> Expected the error to name the file: %v", name, err)
+		}
+
+		if _, err = mpq.OpenFileContext(ctx, name); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s> Expected context.Canceled, got: %v", name, err)
+		}
+	}
+}
+
+func TestMPQ_ExtractContext(t *testing.T) {
+	data := testData(5000)
+	archive := (&testArchive{}).
+		add("file", data, fileFlagExists|fileFlagCompress).
+		add(`dir\empty`, nil, fileFlagExists).
+		add("deleted", nil, fileFlagExists|fileFlagDelete).
+		build(t)
+	mpq := openTestArchive(t, archive)
+
+	buffer := &bytes.Buffer{}
+	n, err := mpq.ExtractContext(context.Background(), "file", buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || !bytes.Equal(buffer.Bytes(), data) {
+		t.Error("Wrong contents.")
+	}
+
+	dir, err := ioutil.TempDir("", "mpq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = mpq.ExtractAllContext(context.Background(), dir); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "file"))
+	if err != nil || !bytes.Equal(contents, data) {
+		t.Error("Wrong extracted contents:", err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "dir", "empty")); err != nil || info.Size() != 0 {
+		t.Error("Expected an empty file:", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "deleted")); !os.IsNotExist(err) {
+		t.Error("Deletion markers should not be extracted:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = mpq.ExtractAllContext(ctx, dir); !errors.Is(err, context.Canceled) {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+}
+
+func TestMPQ_ExtractAllContextEscape(t *testing.T) {
+	archive := (&testArchive{}).
+		add(`..\escape`, []byte("escape"), fileFlagExists).
+		build(t)
+	mpq := openTestArchive(t, archive)
+
+	dir, err := ioutil.TempDir("", "mpq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	if err = mpq.ExtractAllContext(context.Background(), target); err == nil {
+		t.Error("Expected an error for a name outside the directory.")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error("File was written outside the directory:", err)
+	}
+}
@@ -3,6 +3,7 @@ package mpq
 import (
 	"encoding/binary"
 	"io"
+	"strings"
 )
 
 const (
@@ -41,35 +42,91 @@ func initCrypto() {
 	}
 }
 
+// decryptReader decrypts a stream of size bytes. Like decryptBlock it decrypts
+// the whole words and passes the bytes after the last one as is. Bytes of a
+// word that is split between reads are held back until the word is complete.
 type decryptReader struct {
-	reader            io.Reader
-	key1, key2, value uint32
+	reader     io.Reader
+	key1, key2 uint32
+	// words is the amount of whole words left to decrypt.
+	words int64
+
+	partial [4]byte
+	held    int
+	// pending is decrypted data that did not fit the buffer of a Read, err
+	// is the error of the inner reader that is returned once it is read.
+	pending []byte
+	err     error
 }
 
-func newDecryptReader(reader io.Reader, key1 uint32) *decryptReader {
+func newDecryptReader(reader io.Reader, key1 uint32, size int64) *decryptReader {
 	return &decryptReader{
 		reader: reader,
 		key1:   key1,
 		key2:   0xEEEEEEEE,
+		words:  size / 4,
 	}
 }
 
-func (d *decryptReader) Read(buf []byte) (n int, err error) {
-	n, err = d.reader.Read(buf)
+func (d *decryptReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
 
-	length := n >> 2
-	for i := 0; i < length*4; i += 4 {
+	for {
+		if len(d.pending) > 0 {
+			n := copy(buf, d.pending)
+			d.pending = d.pending[n:]
+			return n, nil
+		}
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.words == 0 && d.held == 0 {
+			return d.reader.Read(buf)
+		}
+
+		n, err := d.reader.Read(buf)
+		data := append(d.partial[:d.held:d.held], buf[:n]...)
+
+		words := int64(len(data) / 4)
+		if words > d.words {
+			words = d.words
+		}
+		d.decrypt(data[:words*4])
+		d.words -= words
+
+		// Bytes of an incomplete word are held back, unless the stream ends
+		// before the word does.
+		d.held = 0
+		if rest := len(data) - int(words)*4; d.words > 0 && rest > 0 && err == nil {
+			d.held = copy(d.partial[:], data[words*4:])
+			data = data[:len(data)-rest]
+		}
+
+		n = copy(buf, data)
+		if n < len(data) {
+			d.pending = append([]byte(nil), data[n:]...)
+			d.err, err = err, nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// decrypt whole words continuing the key stream.
+func (d *decryptReader) decrypt(data []byte) {
+	for i := 0; i+4 <= len(data); i += 4 {
 		d.key2 += cryptTable[0x400+(d.key1&0xFF)]
 
-		value := binary.LittleEndian.Uint32(buf[i:])
+		value := binary.LittleEndian.Uint32(data[i:])
 		value ^= (d.key1 + d.key2)
-		binary.LittleEndian.PutUint32(buf[i:], value)
+		binary.LittleEndian.PutUint32(data[i:], value)
 
 		d.key1 = ((^d.key1 << 0x15) + 0x11111111) | (d.key1 >> 0x0B)
 		d.key2 = value + d.key2 + (d.key2 << 5) + 3
 	}
-
-	return n, err
 }
 
 func decryptBlock(block []byte, length int, key1 uint32) {
@@ -89,3 +146,46 @@ func decryptBlock(block []byte, length int, key1 uint32) {
 		key2 = value + key2 + (key2 << 5) + 3
 	}
 }
+
+// fileKey is the key a file is encrypted with. It is derived from the name of
+// the file without its path, and for files flagged with fileFlagFixKey from
+// its position and size as well.
+func fileKey(file *File) uint32 {
+	name := file.Name
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	key := blizz(name, blizzHashFileKey)
+	if file.Flags&fileFlagFixKey != 0 {
+		key = (key + uint32(file.Position)) ^ uint32(file.FileSize)
+	}
+	return key
+}
+
+// recoverKey finds the key of an encrypted block whose first word is known to
+// be plain. The second word decrypted with a candidate key is passed to check,
+// which accepts or rejects the key.
+func recoverKey(block []byte, plain uint32, check func(second uint32) bool) (uint32, bool) {
+	if len(block) < 8 {
+		return 0, false
+	}
+
+	// The first word is xored with key1 + 0xEEEEEEEE + cryptTable[0x400+(key1&0xFF)],
+	// so each possible low byte of key1 gives one candidate.
+	first := binary.LittleEndian.Uint32(block[0:4]) ^ plain
+	for i := uint32(0); i < 0x100; i++ {
+		key := first - 0xEEEEEEEE - cryptTable[0x400+i]
+		if key&0xFF != i {
+			continue
+		}
+
+		words := append([]byte(nil), block[:8]...)
+		decryptBlock(words, len(words), key)
+		if binary.LittleEndian.Uint32(words[0:4]) == plain && check(binary.LittleEndian.Uint32(words[4:8])) {
+			return key, true
+		}
+	}
+
+	return 0, false
+}
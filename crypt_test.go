@@ -0,0 +1,86 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecryptReader(t *testing.T) {
+	const key = 0x7E3F1A25
+	readers := map[string]func(io.Reader) io.Reader{
+		"Plain":     func(r io.Reader) io.Reader { return r },
+		"OneByte":   iotest.OneByteReader,
+		"Half":      iotest.HalfReader,
+		"DataErr":   iotest.DataErrReader,
+		"OneByteDE": func(r io.Reader) io.Reader { return iotest.DataErrReader(iotest.OneByteReader(r)) },
+	}
+
+	for _, size := range []int{0, 3, 4, 5, 1000, 1003} {
+		data := testData(size)
+		encrypted := append([]byte(nil), data...)
+		encryptBlock(encrypted, key)
+
+		for name, wrap := range readers {
+			result, err := ioutil.ReadAll(newDecryptReader(wrap(bytes.NewReader(encrypted)), key, int64(size)))
+			if err != nil {
+				t.Errorf("%s %d: %v", name, size, err)
+			} else if !bytes.Equal(result, data) {
+				t.Errorf("%s %d: Decrypted data differs", name, size)
+			}
+		}
+	}
+}
+
+func TestDecryptReader_SmallBuffer(t *testing.T) {
+	data := testData(101)
+	encrypted := append([]byte(nil), data...)
+	encryptBlock(encrypted, 1)
+
+	// Reads of fewer bytes than a word keep the rest of it for the next read.
+	reader := newDecryptReader(iotest.HalfReader(bytes.NewReader(encrypted)), 1, int64(len(data)))
+	var result []byte
+	buffer := make([]byte, 3)
+	for {
+		n, err := reader.Read(buffer)
+		result = append(result, buffer[:n]...)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(result, data) {
+		t.Error("Decrypted data differs")
+	}
+}
+
+func TestDecryptReader_HeldEOF(t *testing.T) {
+	// The last read of the inner reader returns its data with io.EOF, the
+	// bytes that do not fit the buffer must still be read before the EOF.
+	for _, size := range []int{5, 6, 9, 101} {
+		data := testData(size)
+		encrypted := append([]byte(nil), data...)
+		encryptBlock(encrypted, 1)
+
+		reader := newDecryptReader(iotest.DataErrReader(iotest.HalfReader(bytes.NewReader(encrypted))), 1, int64(size))
+		var result []byte
+		buffer := make([]byte, 3)
+		for {
+			n, err := reader.Read(buffer)
+			result = append(result, buffer[:n]...)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !bytes.Equal(result, data) {
+			t.Errorf("%d: Read %d bytes, decrypted data differs", size, len(result))
+		}
+	}
+}
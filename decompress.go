@@ -1,8 +1,9 @@
 package mpq
 
 import (
+	"bytes"
 	"compress/bzip2"
-	"errors"
+	"compress/zlib"
 	"io"
 )
 
@@ -18,86 +19,52 @@ const (
 	compressionNextSame    = 0xFFFFFFFF // Same compression
 )
 
-type decompressReader struct {
-	reader     io.Reader
-	length     uint64
-	algorithms byte
-}
-
-// newDecompressReader actually has to do a read to determine the algorithm immediately to set things up
-// right. So this may fail due to IO reasons.
+// newDecompressReader reads the compression mask in front of the data to set
+// up the matching decompressor, so this may fail due to IO reasons.
 func newDecompressReader(reader io.Reader, length uint64) (io.Reader, error) {
 	var compressionAlgorithm [1]byte
-	_, err := reader.Read(compressionAlgorithm[:])
-	if err != nil {
+	if _, err := io.ReadFull(reader, compressionAlgorithm[:]); err != nil {
 		return nil, err
 	}
 
 	switch compressionAlgorithm[0] {
-	case compressionHuffman:
-		return nil, errors.New("Huffman compression not supported")
-	case compressionLZMA:
-		return nil, errors.New("LZMA compression not supported")
 	case compressionZlib:
-		return nil, errors.New("Zlib compression not supported")
+		return zlib.NewReader(reader)
 	case compressionBzip2:
 		return bzip2.NewReader(reader), nil
-	case compressionPkware:
-		return nil, errors.New("PKWare compression not supported")
-	case compressionSparse:
-		return nil, errors.New("Sparse compression not supported")
-	case compressionSparse | compressionZlib:
-		return nil, errors.New("Sparse+Zlib compression not supported")
-	case compressionSparse | compressionBzip2:
-		return nil, errors.New("Sparse+Bzip2 compression not supported")
-	case compressionADPCMono | compressionHuffman:
-		return nil, errors.New("ADPCMMono+Huffman compression not supported")
-	case compressionADPCMStereo | compressionHuffman:
-		return nil, errors.New("ADPCMStereo+Huffman compression not supported")
 	}
 
-	return &decompressReader{
-		reader:     reader,
-		length:     length,
-		algorithms: compressionAlgorithm[0],
-	}, nil
-}
-
-func (d *decompressReader) Read(buf []byte) (n int, err error) {
-	return 0, io.EOF
+	return nil, &UnsupportedCompressionError{Mask: compressionAlgorithm[0]}
 }
 
 func decompress(dest []byte, src []byte) error {
 	if len(dest) == len(src) {
 		copy(dest, src)
 		return nil
+	} else if len(src) == 0 {
+		return corruptError("Compressed data is empty")
 	}
 
 	offset := 0
 	compressionMethod := src[offset]
 	offset++
 
+	var reader io.Reader
+	var err error
+
 	switch compressionMethod {
-	case compressionHuffman:
-		return errors.New("Huffman compression not supported")
-	case compressionLZMA:
-		return errors.New("LZMA compression not supported")
 	case compressionZlib:
-		return errors.New("Zlib compression not supported")
+		if reader, err = zlib.NewReader(bytes.NewReader(src[offset:])); err != nil {
+			return err
+		}
 	case compressionBzip2:
-		return errors.New("Bzip2 compression not supported")
-	case compressionPkware:
-		return errors.New("PKWare compression not supported")
-	case compressionSparse:
-		return errors.New("Sparse compression not supported")
-	case compressionSparse | compressionZlib:
-		return errors.New("Sparse+Zlib compression not supported")
-	case compressionSparse | compressionBzip2:
-		return errors.New("Sparse+Bzip2 compression not supported")
-	case compressionADPCMono | compressionHuffman:
-		return errors.New("ADPCMMono+Huffman compression not supported")
-	case compressionADPCMStereo | compressionHuffman:
-		return errors.New("ADPCMStereo+Huffman compression not supported")
+		reader = bzip2.NewReader(bytes.NewReader(src[offset:]))
+	default:
+		return &UnsupportedCompressionError{Mask: compressionMethod}
+	}
+
+	if _, err = io.ReadFull(reader, dest); err != nil {
+		return err
 	}
 
 	return nil
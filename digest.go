@@ -0,0 +1,175 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+)
+
+// headerDigestSize is the amount of bytes of a v4 header covered by MPQHeaderMD5.
+const headerDigestSize = 0xC0
+
+// ErrDigestMismatch occurs when part of an archive does not match its MD5 digest.
+var ErrDigestMismatch = errors.New("MD5 digest mismatch")
+
+// DigestCheck is the result of comparing part of the archive to an MD5 digest
+// stored in the archive.
+type DigestCheck struct {
+	// Structure is the name of the part of the archive that was checked.
+	Structure string
+	// Offset and Size of the checked bytes, relative to the archive header.
+	Offset int64
+	Size   int64
+
+	Expected []byte
+	Actual   []byte
+}
+
+// OK is true if the digest matched.
+func (d DigestCheck) OK() bool {
+	return bytes.Equal(d.Expected, d.Actual)
+}
+
+// DigestsOK is true if every digest in the Digests field matched.
+func (m *MPQ) DigestsOK() bool {
+	for _, check := range m.Digests {
+		if !check.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// checkDigests compares the header and each table of a v4 archive against the
+// MD5s stored in the header and fills in the Digests field. The HET and BET
+// tables are additionally followed by the per-chunk MD5s of their raw data.
+func (m *MPQ) checkDigests() error {
+	h := m.Header
+
+	tables := []struct {
+		structure string
+		position  int64
+		size      uint64
+		expected  []byte
+		chunked   bool
+	}{
+		{"header", 0, headerDigestSize, h.MPQHeaderMD5, false},
+		{"HET table", int64(h.HETTablePos), h.HETTableSize64, h.HETTableMD5, true},
+		{"BET table", int64(h.BETTablePos), h.BETTableSize64, h.BETTableMD5, true},
+		{"hash table", (int64(h.HashTablePosHi) << 32) | int64(h.HashTablePos), h.HashTableSize64, h.HashTableMD5, false},
+		{"block table", (int64(h.BlockTablePosHi) << 32) | int64(h.BlockTablePos), h.BlockTableSize64, h.BlockTableMD5, false},
+		{"hi-block table", int64(h.HiBlockTablePos), h.HiBlockTableSize64, h.HiBlockTableMD5, false},
+	}
+
+	m.Digests = nil
+	for _, table := range tables {
+		if table.size == 0 || isZero(table.expected) {
+			continue
+		}
+
+		if err := m.limits().checkTable(table.structure, table.size, table.size); err != nil {
+			return err
+		}
+		if err := m.checkRange(table.structure, table.position, table.size); err != nil {
+			return err
+		}
+
+		raw := make([]byte, table.size)
+		if err := m.readAt(raw, table.position); err != nil {
+			return err
+		}
+
+		sum := md5.Sum(raw)
+		m.Digests = append(m.Digests, DigestCheck{
+			Structure: table.structure,
+			Offset:    table.position,
+			Size:      int64(table.size),
+			Expected:  table.expected,
+			Actual:    sum[:],
+		})
+
+		if table.chunked && h.ChunkSize != 0 {
+			checks, err := m.checkChunks(table.structure, table.position, int64(table.size))
+			if err != nil {
+				return err
+			}
+			m.Digests = append(m.Digests, checks...)
+		}
+	}
+
+	for _, check := range m.Digests {
+		if check.OK() {
+			continue
+		}
+		if m.opts.strictDigests {
+			return fmt.Errorf("%s: %w", check.Structure, ErrDigestMismatch)
+		}
+		if err := m.warn(WarnDigest, check.Structure, check.Offset, "", "MD5 digest does not match"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkChunks compares the raw data at position against the array of MD5s
+// that follows it. The array holds the MD5 of every Header.ChunkSize bytes.
+func (m *MPQ) checkChunks(structure string, position, size int64) ([]DigestCheck, error) {
+	chunkSize := int64(m.Header.ChunkSize)
+	count := (size + chunkSize - 1) / chunkSize
+
+	if err := m.checkRange(structure+" chunk MD5s", position+size, uint64(count*digestSize)); err != nil {
+		return nil, err
+	}
+
+	expected := make([]byte, count*digestSize)
+	if err := m.readAt(expected, position+size); err != nil {
+		return nil, err
+	}
+
+	checks := make([]DigestCheck, 0, count)
+	chunk := make([]byte, chunkSize)
+	for i := int64(0); i < count; i++ {
+		offset := i * chunkSize
+		length := chunkSize
+		if size-offset < length {
+			length = size - offset
+		}
+
+		if err := m.readAt(chunk[:length], position+offset); err != nil {
+			return nil, err
+		}
+
+		sum := md5.Sum(chunk[:length])
+		checks = append(checks, DigestCheck{
+			Structure: fmt.Sprintf("%s chunk %d", structure, i),
+			Offset:    position + offset,
+			Size:      length,
+			Expected:  expected[i*digestSize : (i+1)*digestSize],
+			Actual:    sum[:],
+		})
+	}
+
+	return checks, nil
+}
+
+// VerifyRawData compares the stored bytes of a file in a v4 archive against
+// the per-chunk MD5s that follow them. It returns nil if the archive does not
+// store chunk MD5s.
+func (m *MPQ) VerifyRawData(file *File) ([]DigestCheck, error) {
+	if m.Header.FormatVersion < mpqFormatVersion4 || m.Header.ChunkSize == 0 || file.CompressedSize == 0 {
+		return nil, nil
+	}
+
+	return m.checkChunks(file.Name, int64(file.Position), int64(file.CompressedSize))
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDigests(t *testing.T) {
+	setup()
+
+	structures := []string{
+		"header",
+		"HET table",
+		"HET table chunk 0",
+		"BET table",
+		"BET table chunk 0",
+		"hash table",
+		"block table",
+	}
+
+	if len(m.Digests) != len(structures) {
+		t.Fatal("Wrong number of digests checked:", len(m.Digests))
+	}
+
+	for i, check := range m.Digests {
+		if check.Structure != structures[i] {
+			t.Errorf("%d> Wrong structure: %s", i, check.Structure)
+		}
+		if !check.OK() {
+			t.Errorf("%s> Digest mismatch\nExpected: % 02X\nGot     : % 02X", check.Structure, check.Expected, check.Actual)
+		}
+	}
+
+	if !m.DigestsOK() {
+		t.Error("Digests should be OK.")
+	}
+}
+
+func TestDigests_Mismatch(t *testing.T) {
+	archive, err := ioutil.ReadFile("Garden of Terror (72).StormReplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Damage the first byte of BlockTableMD5 in the header.
+	archive[1024+0x70] ^= 0xFF
+
+	mpq, err := OpenReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failed []string
+	for _, check := range mpq.Digests {
+		if !check.OK() {
+			failed = append(failed, check.Structure)
+		}
+	}
+
+	if len(failed) != 2 || failed[0] != "header" || failed[1] != "block table" {
+		t.Error("Wrong digests failed:", failed)
+	}
+
+	_, err = OpenReader(bytes.NewReader(archive), StrictDigests())
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Error("Expected a digest mismatch, got:", err)
+	}
+}
+
+func TestDigests_RawData(t *testing.T) {
+	archive, err := ioutil.ReadFile("Garden of Terror (72).StormReplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mpq, err := OpenReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := mpq.FileList["replay.game.events"]
+	checks, err := mpq.VerifyRawData(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 22 {
+		t.Error("Wrong number of chunks:", len(checks))
+	}
+	for _, check := range checks {
+		if !check.OK() {
+			t.Errorf("%s> Digest mismatch", check.Structure)
+		}
+	}
+
+	// Damage the second chunk of the file.
+	archive[1024+int(file.Position)+0x4000] ^= 0xFF
+
+	checks, err = mpq.VerifyRawData(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, check := range checks {
+		if check.OK() != (i != 1) {
+			t.Errorf("%s> Wrong result: %v", check.Structure, check.OK())
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package mpq
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// These errors can be matched with errors.Is against the errors returned
+// while opening and reading an archive.
+var (
+	// ErrHeaderNotFound occurs when a stream does not contain an MPQ header.
+	ErrHeaderNotFound = errors.New("Could not find MPQ header.")
+	// ErrCorrupt matches errors about parts of an archive that hold values
+	// that can not be right.
+	ErrCorrupt = errors.New("Archive is corrupt")
+	// ErrUnsupported matches errors about compression, encryption and other
+	// features this package can not decode.
+	ErrUnsupported = errors.New("Not supported")
+)
+
+// FormatError describes the part of an archive that could not be read.
+type FormatError struct {
+	// Archive is the file name of the archive if it was opened by name.
+	Archive string
+	// Name of the file in the archive the error occurred in, if any.
+	Name string
+	// Structure is the part of the archive, such as "hash table" or "file".
+	Structure string
+	// Offset of the structure in the stream.
+	Offset int64
+
+	Err error
+}
+
+func (f *FormatError) Error() string {
+	var parts []string
+	if f.Archive != "" {
+		parts = append(parts, f.Archive)
+	}
+	if f.Name != "" {
+		parts = append(parts, f.Name)
+	}
+	parts = append(parts, fmt.Sprintf("%s at 0x%X", f.Structure, f.Offset), f.Err.Error())
+	return strings.Join(parts, ": ")
+}
+
+func (f *FormatError) Unwrap() error {
+	return f.Err
+}
+
+// formatError wraps err with the structure it occurred in and its position
+// relative to the archive start. Errors that already are a *FormatError are
+// returned as they are.
+func (m *MPQ) formatError(structure string, position int64, err error) error {
+	var formatErr *FormatError
+	if errors.As(err, &formatErr) {
+		return err
+	}
+	return &FormatError{Structure: structure, Offset: m.offset + position, Err: err}
+}
+
+// withArchive sets the archive file name of a *FormatError.
+func withArchive(err error, filename string) error {
+	var formatErr *FormatError
+	if errors.As(err, &formatErr) {
+		formatErr.Archive = filename
+	}
+	return err
+}
+
+// UnsupportedCompressionError occurs when data is compressed with a
+// combination of methods this package can not decompress.
+type UnsupportedCompressionError struct {
+	Mask byte
+}
+
+var compressionNames = []struct {
+	mask byte
+	name string
+}{
+	{compressionSparse, "Sparse"},
+	{compressionADPCMono, "ADPCMMono"},
+	{compressionADPCMStereo, "ADPCMStereo"},
+	{compressionHuffman, "Huffman"},
+	{compressionZlib, "Zlib"},
+	{compressionPkware, "PKWare"},
+	{compressionBzip2, "Bzip2"},
+}
+
+func (u *UnsupportedCompressionError) Error() string {
+	if u.Mask == compressionLZMA {
+		return "LZMA compression not supported"
+	}
+
+	var names []string
+	mask := u.Mask
+	for _, compression := range compressionNames {
+		if mask&compression.mask != 0 {
+			names = append(names, compression.name)
+			mask &^= compression.mask
+		}
+	}
+	if len(names) == 0 || mask != 0 {
+		return fmt.Sprintf("Compression 0x%02X not supported", u.Mask)
+	}
+	return strings.Join(names, "+") + " compression not supported"
+}
+
+// Is makes the error match ErrUnsupported.
+func (u *UnsupportedCompressionError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// unsupportedError is returned when a file is stored using a method
+// this package cannot decode.
+type unsupportedError string
+
+func (u unsupportedError) Error() string {
+	return string(u)
+}
+
+// Is makes the error match ErrUnsupported.
+func (u unsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// corruptError is returned when part of an archive holds invalid values.
+type corruptError string
+
+func (c corruptError) Error() string {
+	return string(c)
+}
+
+// Is makes the error match ErrCorrupt.
+func (c corruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
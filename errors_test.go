@@ -0,0 +1,114 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatError(t *testing.T) {
+	err := &FormatError{Archive: "a.mpq", Name: "file", Structure: "file", Offset: 0x200, Err: corruptError("Bad")}
+	if msg := err.Error(); msg != "a.mpq: file: file at 0x200: Bad" {
+		t.Error("Wrong message:", msg)
+	}
+	if !errors.Is(err, ErrCorrupt) {
+		t.Error("FormatError should unwrap to ErrCorrupt.")
+	}
+	if errors.Is(err, ErrUnsupported) {
+		t.Error("FormatError should not match ErrUnsupported.")
+	}
+
+	err = &FormatError{Structure: "header", Err: ErrHeaderNotFound}
+	if msg := err.Error(); msg != "header at 0x0: Could not find MPQ header." {
+		t.Error("Wrong message:", msg)
+	}
+}
+
+func TestUnsupportedCompressionError(t *testing.T) {
+	tests := []struct {
+		mask    byte
+		message string
+	}{
+		{compressionSparse | compressionZlib, "Sparse+Zlib compression not supported"},
+		{compressionLZMA, "LZMA compression not supported"},
+		{compressionADPCMono | compressionHuffman, "ADPCMMono+Huffman compression not supported"},
+		{0x04, "Compression 0x04 not supported"},
+	}
+
+	for _, test := range tests {
+		err := &UnsupportedCompressionError{Mask: test.mask}
+		if msg := err.Error(); msg != test.message {
+			t.Errorf("Mask %02X: Wrong message: %s", test.mask, msg)
+		}
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Mask %02X: Should match ErrUnsupported.", test.mask)
+		}
+	}
+
+	if err := decompress(make([]byte, 8), []byte{compressionLZMA, 0}); !errors.As(err, new(*UnsupportedCompressionError)) {
+		t.Error("Expected an UnsupportedCompressionError, got:", err)
+	}
+}
+
+func TestOpen_FormatError(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("file", testData(3000), fileFlagExists|fileFlagCompress)
+	data := archive.build(t)
+
+	checkError := func(err error, structure string, offset int64, target error) *FormatError {
+		t.Helper()
+		var formatErr *FormatError
+		if !errors.As(err, &formatErr) {
+			t.Fatal("Expected a FormatError, got:", err)
+		}
+		if formatErr.Structure != structure || formatErr.Offset != offset {
+			t.Errorf("Wrong structure or offset: %s at 0x%X", formatErr.Structure, formatErr.Offset)
+		}
+		if !errors.Is(err, target) {
+			t.Errorf("Expected error to match %v, got: %v", target, err)
+		}
+		return formatErr
+	}
+
+	_, err := OpenBytes(make([]byte, 2048))
+	checkError(err, "header", 0, ErrHeaderNotFound)
+
+	corrupt := append([]byte(nil), data...)
+	binary.LittleEndian.PutUint16(corrupt[14:16], 30)
+	_, err = OpenBytes(corrupt)
+	checkError(err, "header", 0, ErrCorrupt)
+
+	corrupt = append([]byte(nil), data...)
+	binary.LittleEndian.PutUint32(corrupt[16:20], uint32(len(data)))
+	_, err = OpenBytes(corrupt)
+	checkError(err, "hash table", int64(len(data)), ErrCorrupt)
+
+	dir, err := ioutil.TempDir("", "mpq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "corrupt.mpq")
+	if err = ioutil.WriteFile(filename, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Open(filename)
+	if formatErr := checkError(err, "hash table", int64(len(data)), ErrCorrupt); formatErr.Archive != filename {
+		t.Error("Wrong archive name:", formatErr.Archive)
+	}
+
+	mpq := openTestArchive(t, data)
+	file, err := mpq.FileInfo("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.CompressedSize = uint64(len(data))
+	_, err = mpq.open(file)
+	if formatErr := checkError(err, "file", int64(file.Position), ErrCorrupt); formatErr.Name != "file" {
+		t.Error("Wrong file name:", formatErr.Name)
+	}
+}
@@ -2,10 +2,12 @@ package mpq
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"sort"
+	"time"
 )
 
 const (
@@ -30,23 +32,70 @@ var (
 	// ErrFileDeleted occurs when the filename given is present in the BET/Block tables
 	// but has been flagged as deleted.
 	ErrFileDeleted = errors.New("File has been removed from the archive")
-	// ErrFileEmpty occurs when the file is of size 0 bytes inside the archive.
+	// ErrFileEmpty is no longer returned, files of size 0 bytes open as
+	// empty readers.
 	ErrFileEmpty = errors.New("File is empty")
+	// ErrSectorChecksum occurs when reading a sector whose adler32 checksum
+	// does not match the one stored with the file.
+	ErrSectorChecksum = errors.New("Sector checksum mismatch")
 )
 
 // File represents a file in the MPQ archive.
 type File struct {
-	Name   string
-	Locale uint16
+	Name     string
+	Locale   uint16
+	Platform uint16
 
 	FileSize       uint64
 	CompressedSize uint64
 	Position       uint64
 
 	Flags uint32
+	// FlagIndex is the index of Flags in the flags of the BET table.
+	FlagIndex uint32
+
+	// BlockIndex is the index of the entry in the BET table, or in the block
+	// table if the archive has no BET table.
+	BlockIndex int
+	// HashIndex is the slot of the entry in the HET table, or in the hash
+	// table if the archive has no HET table. It is -1 for a file opened by
+	// its BlockIndex.
+	HashIndex int
+
+	// These fields are decoded from Flags.
+	IsEncrypted  bool
+	HasFixKey    bool
+	IsSingleUnit bool
+	HasSectorCRC bool
+	IsCompressed bool
+	IsImploded   bool
+
+	// These fields are filled from the (attributes) file when the archive has one.
+	CRC32   uint32
+	ModTime time.Time
+	MD5     []byte
+	IsPatch bool
+
+	// IsDeletionMarker is set for entries that mark the file as deleted in
+	// the archives an ArchiveSet searches after this one. Opening one returns
+	// ErrFileDeleted.
+	IsDeletionMarker bool
 }
 
-// Open the file for reading.
+// decodeFlags sets the fields that are decoded from Flags.
+func (f *File) decodeFlags() {
+	f.IsEncrypted = f.Flags&fileFlagEncrypted != 0
+	f.HasFixKey = f.Flags&fileFlagFixKey != 0
+	f.IsSingleUnit = f.Flags&fileFlagSingleUnit != 0
+	f.HasSectorCRC = f.Flags&fileFlagSectorCRC != 0
+	f.IsCompressed = f.Flags&fileFlagCompress != 0
+	f.IsImploded = f.Flags&fileFlagImplode != 0
+	f.IsPatch = f.Flags&fileFlagPatchFile != 0
+	f.IsDeletionMarker = f.Flags&fileFlagDelete != 0
+}
+
+// Open the file for reading. Files flagged as patch files read as the PTCH
+// data they contain, use ArchiveSet to read the patched file.
 func (m *MPQ) Open(filename string) (io.Reader, error) {
 	var file *File
 	var ok bool
@@ -57,184 +106,352 @@ func (m *MPQ) Open(filename string) (io.Reader, error) {
 	return m.open(file)
 }
 
+// OpenIndex opens the file of an entry of the BET table, or of the block table
+// if the archive has no BET table, for files whose name is not known. The key
+// of an encrypted file is recovered from its sector offset table.
+func (m *MPQ) OpenIndex(index int) (io.Reader, error) {
+	file, err := m.fileAt(index)
+	if err != nil {
+		return nil, err
+	}
+	return m.open(file)
+}
+
 func (m *MPQ) open(file *File) (io.Reader, error) {
-	if file.Position == 0 || file.FileSize == 0 || file.CompressedSize == 0 {
-		return nil, ErrFileEmpty
+	reader, err := m.openFile(file)
+	if err != nil && err != ErrFileDeleted {
+		return nil, &FormatError{Name: file.Name, Structure: "file", Offset: m.offset + int64(file.Position), Err: err}
+	}
+	return reader, err
+}
+
+func (m *MPQ) openFile(file *File) (io.Reader, error) {
+	if file.Flags&fileFlagDelete != 0 || file.Flags&fileFlagExists == 0 {
+		return nil, ErrFileDeleted
+	}
+	if file.FileSize == 0 {
+		return bytes.NewReader(nil), nil
+	}
+	if file.CompressedSize == 0 {
+		return nil, corruptError("File has no data")
+	}
+
+	// The key depends on the position of the file, not of the data in it.
+	var key uint32
+	if file.Flags&fileFlagEncrypted != 0 && file.Name != "" {
+		key = fileKey(file)
 	}
 
 	var err error
-	if _, err = m.reader.Seek(m.offset+int64(file.Position), 0); err != nil {
-		return nil, fmt.Errorf("Failed to seek to file position: %v", err)
+	if file.Flags&fileFlagPatchFile != 0 {
+		if file, err = m.patchData(file); err != nil {
+			return nil, err
+		}
 	}
 
-	reader := io.LimitReader(m.reader, int64(file.CompressedSize))
+	if err = m.limits().checkFile(file); err != nil {
+		return nil, err
+	}
+	if err = m.checkRange(file.Name, int64(file.Position), file.CompressedSize); err != nil {
+		return nil, err
+	}
 
-	if file.Flags&fileFlagExists == 0 {
-		return nil, ErrFileDeleted
+	reader := m.section(int64(file.Position), int64(file.CompressedSize))
+
+	if isStoredPlain(file) {
+		return reader, nil
 	}
 
 	if file.Flags&fileFlagSingleUnit == 0 {
-		return nil, errors.New("Cannot process multi-unit files")
+		if file.Flags&fileFlagImplode != 0 {
+			return nil, unsupportedError("PKWARE Implode Compression not supported")
+		}
+		if file.Flags&fileFlagCompress != 0 && m.Header.FormatVersion < mpqFormatVersion2 {
+			return nil, unsupportedError("Oldschool MPQ multiple compression is not supported")
+		}
+		return newSectorReader(m, file, key)
 	}
 
 	if file.Flags&fileFlagEncrypted != 0 {
-		key := blizz(file.Name, blizzHashFileKey)
-		if file.Flags&fileFlagFixKey != 0 {
-			key = (key + uint32(m.offset)) ^ uint32(file.FileSize)
+		if file.Name == "" {
+			return nil, unsupportedError("Cannot find the key of an encrypted single unit file without a name")
 		}
-		reader = newDecryptReader(reader, key)
+		reader = newDecryptReader(reader, key, int64(file.CompressedSize))
 	}
 
 	if file.Flags&fileCompressedMask != 0 && file.FileSize != file.CompressedSize {
+		if err = m.limits().checkRatio("File", file.FileSize, file.CompressedSize); err != nil {
+			return nil, err
+		}
 		if file.Flags&fileFlagCompress != 0 {
 			if m.Header.FormatVersion >= mpqFormatVersion2 {
-				reader, err = newDecompressReader(reader, file.FileSize)
+				if reader, err = newDecompressReader(reader, file.FileSize); err == nil {
+					reader = io.LimitReader(reader, int64(file.FileSize))
+				}
 			} else {
-				err = errors.New("Oldschool MPQ multiple compression is not supported")
+				err = unsupportedError("Oldschool MPQ multiple compression is not supported")
 			}
 		} else if file.Flags&fileFlagImplode != 0 {
-			err = errors.New("PKWARE Implode Compression not supported")
+			err = unsupportedError("PKWARE Implode Compression not supported")
 		}
 	}
 
 	return reader, err
 }
 
+// readFile reads the whole contents of a file.
+func (m *MPQ) readFile(file *File) ([]byte, error) {
+	reader, err := m.open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(reader)
+}
+
 // buildFileList attempts to use the read in structures to create a file listing.
 func (m *MPQ) buildFileList() error {
 	var err error
 	var file *File
 
-	listInfo, err := m.FileInfo("(listfile)")
-	if err != nil {
-		return err
-	}
-
-	list, err := m.open(listInfo)
+	listInfo, names, err := m.readListfile()
 	if err != nil {
-		return err
-	}
-
-	m.fileNames = make([]string, 0)
-	scanner := bufio.NewScanner(list)
-	for scanner.Scan() {
-		m.fileNames = append(m.fileNames, scanner.Text())
+		if !m.lenient() {
+			return err
+		}
+		m.warn(WarnNoListfile, "(listfile)", 0, "(listfile)", err.Error())
 	}
 
-	if err := scanner.Err(); err != nil {
-		return err
+	m.fileNames = make([]string, 0, len(names))
+	for _, fileName := range names {
+		if _, ok := m.FileList[fileName]; ok || fileName == "" {
+			continue
+		}
+		if file, err = m.FileInfo(fileName); err == nil {
+			m.FileList[fileName] = file
+			m.fileNames = append(m.fileNames, fileName)
+		} else if err = m.warn(WarnListfileName, "(listfile)", int64(listInfo.Position), fileName, err.Error()); err != nil {
+			return err
+		}
 	}
 
 	// Make sure to fetch special file info.
-	m.fileNames = append(m.fileNames, []string{"(attributes)", "(userdata)"}...)
-
-	for i := 0; i < len(m.fileNames); i++ {
-		fileName := m.fileNames[i]
-		if file, err = m.FileInfo(fileName); err != nil {
-			if i+1 != len(m.fileNames) {
-				m.fileNames[i], m.fileNames[i+1] = m.fileNames[i+1], m.fileNames[i]
-			}
-			m.fileNames = m.fileNames[:len(m.fileNames)-1]
-		} else if err == nil {
+	for _, fileName := range []string{"(attributes)", "(signature)", "(userdata)", "(patch_metadata)"} {
+		if _, ok := m.FileList[fileName]; ok {
+			continue
+		}
+		if file, err = m.FileInfo(fileName); err == nil {
 			m.FileList[fileName] = file
+			m.fileNames = append(m.fileNames, fileName)
 		}
 	}
 
 	// Add the pre-done list info stuff.
-	m.fileNames = append(m.fileNames, listInfo.Name)
-	m.FileList[listInfo.Name] = listInfo
+	if _, ok := m.FileList["(listfile)"]; !ok && listInfo != nil {
+		m.fileNames = append(m.fileNames, listInfo.Name)
+		m.FileList[listInfo.Name] = listInfo
+	}
 
 	sort.Strings(m.fileNames)
 
 	return nil
 }
 
+// readListfile reads the names in the (listfile).
+func (m *MPQ) readListfile() (*File, []string, error) {
+	listInfo, err := m.FileInfo("(listfile)")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	list, err := m.open(listInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		names = append(names, scanner.Text())
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return listInfo, names, nil
+}
+
 // FileInfo attempts to get the file information for a filename.
 func (m *MPQ) FileInfo(name string) (*File, error) {
+	var file *File
+	var err error
+
 	if m.HETTable != nil && m.BETTable != nil {
-		return m.findFromHETAndBET(name)
+		file, err = m.findFromHETAndBET(name)
 	} else if m.HashTable != nil && m.BlockTable != nil {
-		return m.findFromHashAndBlock(name)
+		file, err = m.findFromHashAndBlock(name)
+	} else {
+		return nil, errors.New("HET, BET, Hash and Block tables are all unavailable")
 	}
 
-	return nil, errors.New("HET, BET, Hash and Block tables are all unavailable")
+	if err != nil {
+		return nil, err
+	}
+
+	m.applyAttributes(file)
+	return file, nil
+}
+
+// applyAttributes fills the fields of a file that come from the (attributes).
+func (m *MPQ) applyAttributes(file *File) {
+	if m.Attributes != nil {
+		m.Attributes.apply(file, file.BlockIndex)
+	}
+}
+
+// blockCount is the amount of entries in the BET table or the block table.
+func (m *MPQ) blockCount() int {
+	if m.HETTable != nil && m.BETTable != nil {
+		return m.BETTable.EntryCount
+	} else if m.BlockTable != nil {
+		return m.BlockTable.EntryCount
+	}
+	return 0
 }
 
 func (m *MPQ) findFromHETAndBET(name string) (*File, error) {
 	hash := (jenkins2(name) & m.HETTable.AndMask) | m.HETTable.OrMask
-	hetHash := byte(hash >> uint(m.HETTable.HashEntrySize-8))
-	betHash := hash & (m.HETTable.AndMask >> 0x08)
 
-	indexes, err := m.HETTable.Indexes()
+	count := len(m.HETTable.Hashes)
+	if count == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	lookup, err := m.hetIndex()
 	if err != nil {
 		return nil, err
 	}
 
-	files, err := m.BETTable.Entries()
+	slot := lookup.find(hash, int(hash%uint64(count)), false)
+	if slot < 0 {
+		return nil, ErrFileNotFound
+	}
+
+	file, err := m.hetSlotFile(slot)
 	if err != nil {
 		return nil, err
 	}
 
-	var betEntry *BETTableEntry
-	for i := int(hash % uint64(m.HETTable.HashTableSize)); i < len(m.HETTable.Hashes); i++ {
-		nameHash1 := m.HETTable.Hashes[i]
-		if nameHash1 == 0 {
-			break
-		}
+	file.Name = name
+	return file, nil
+}
 
-		if hetHash != nameHash1 {
-			continue
-		}
+// hetSlotFile describes the file of the BET table entry a slot of the HET
+// table refers to.
+func (m *MPQ) hetSlotFile(slot int) (*File, error) {
+	index, err := m.HETTable.Index(slot)
+	if err != nil {
+		return nil, err
+	}
+	file, err := m.betFile(index)
+	if err != nil {
+		return nil, err
+	}
 
-		betEntry = &files[int(indexes[i])]
-		if betHash == betEntry.NameHash2 {
-			break
-		}
-		betEntry = nil
+	file.HashIndex = slot
+	return file, nil
+}
+
+func (m *MPQ) findFromHashAndBlock(name string) (*File, error) {
+	hashTableEntries := m.HashTable.Entries()
+	if len(hashTableEntries) == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	lookup, err := m.hashIndex()
+	if err != nil {
+		return nil, err
 	}
 
-	if betEntry == nil {
+	start := blizz(name, blizzHashTableIndex) & uint32(len(hashTableEntries)-1)
+	key := uint64(blizz(name, blizzHashNameA))<<32 | uint64(blizz(name, blizzHashNameB))
+
+	slot := lookup.find(key, int(start), true)
+	if slot < 0 {
 		return nil, ErrFileNotFound
 	}
 
-	return &File{
-		Name:           name,
-		FileSize:       betEntry.FileSize,
-		CompressedSize: betEntry.CompressedSize,
-		Position:       betEntry.FilePosition,
-		Flags:          betEntry.Flags,
-	}, nil
+	file, err := m.hashSlotFile(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	file.Name = name
+	return file, nil
 }
 
-func (m *MPQ) findFromHashAndBlock(name string) (*File, error) {
-	start := blizz(name, blizzHashTableIndex) & uint32(m.Header.HashTableSize-1)
-	name1 := blizz(name, blizzHashNameA)
-	name2 := blizz(name, blizzHashNameB)
+// hashSlotFile describes the file of the block table entry a slot of the hash
+// table refers to.
+func (m *MPQ) hashSlotFile(slot int) (*File, error) {
+	entry := &m.HashTable.Entries()[slot]
+	file, err := m.blockFile(int(entry.BlockIndex))
+	if err != nil {
+		return nil, err
+	}
 
-	hashTableEntries := m.HashTable.Entries()
-	blockTableEntries := m.BlockTable.Entries()
+	file.Locale = entry.Locale
+	file.Platform = entry.Platform
+	file.HashIndex = slot
+	return file, nil
+}
 
-	var blockEntry *BlockTableEntry
-	for i := int(start); i < len(hashTableEntries); i++ {
-		entry := &hashTableEntries[i]
-		if entry.BlockIndex == 0xFFFFFFFF {
-			break
-		}
+// fileAt describes the file of an entry of the BET table, or of the block
+// table if the archive has no BET table. Its name is not known.
+func (m *MPQ) fileAt(index int) (*File, error) {
+	if m.HETTable != nil && m.BETTable != nil {
+		return m.betFile(index)
+	}
+	return m.blockFile(index)
+}
 
-		if name1 == entry.Name1 && name2 == entry.Name2 {
-			blockEntry = &blockTableEntries[entry.BlockIndex]
-		}
+// betFile describes the file of an entry of the BET table.
+func (m *MPQ) betFile(index int) (*File, error) {
+	betEntry, err := m.BETTable.Entry(index)
+	if err != nil {
+		return nil, err
 	}
+	file := &File{
+		FileSize:       betEntry.FileSize,
+		CompressedSize: betEntry.CompressedSize,
+		Position:       betEntry.FilePosition,
+		Flags:          betEntry.Flags,
+		FlagIndex:      betEntry.FlagIndex,
+		BlockIndex:     index,
+		HashIndex:      -1,
+	}
+	file.decodeFlags()
+	return file, nil
+}
 
-	if blockEntry == nil {
+// blockFile describes the file of an entry of the block table.
+func (m *MPQ) blockFile(index int) (*File, error) {
+	if m.BlockTable == nil {
 		return nil, ErrFileNotFound
 	}
+	blockTableEntries := m.BlockTable.Entries()
+	if index < 0 || index >= len(blockTableEntries) {
+		return nil, ErrFileNotFound
+	}
+	blockEntry := &blockTableEntries[index]
 
-	return &File{
-		Name:           name,
+	file := &File{
 		FileSize:       uint64(blockEntry.FileSize),
 		CompressedSize: uint64(blockEntry.CompressedSize),
 		Position:       uint64(blockEntry.FilePosition),
 		Flags:          uint32(blockEntry.Flags),
-	}, nil
+		BlockIndex:     index,
+		HashIndex:      -1,
+	}
+	file.decodeFlags()
+	return file, nil
 }
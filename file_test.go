@@ -152,3 +152,90 @@ func TestFile_FromHashAndBlock(t *testing.T) {
 		t.Errorf("Wrong File Flags: % 02X", file.Flags)
 	}
 }
+
+func TestFile_EmptyAndDeleted(t *testing.T) {
+	archive := &testArchive{noListfile: true}
+	archive.add("(listfile)", []byte("empty\r\nmarker"), fileFlagExists)
+	archive.add("empty", nil, fileFlagExists)
+	archive.add("marker", nil, fileFlagExists|fileFlagDelete)
+	mpq := openTestArchive(t, archive.build(t))
+
+	contents, err := mpq.readAll("empty")
+	if err != nil {
+		t.Error(err)
+	} else if len(contents) != 0 {
+		t.Error("Expected no contents, got:", len(contents))
+	}
+
+	if _, err = mpq.Open("marker"); err != ErrFileDeleted {
+		t.Error("Expected ErrFileDeleted, got:", err)
+	}
+
+	files, err := mpq.ListFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	markers := map[string]bool{}
+	for _, file := range files {
+		markers[file.Name] = file.IsDeletionMarker
+	}
+	if len(markers) != 3 || markers["empty"] || !markers["marker"] || markers["(listfile)"] {
+		t.Error("Wrong deletion markers:", markers)
+	}
+
+	archive = &testArchive{noListfile: true}
+	archive.add("(listfile)", nil, fileFlagExists)
+	mpq = openTestArchive(t, archive.build(t))
+	if files, err := mpq.Files(); err != nil || len(files) != 1 {
+		t.Error("Expected only the (listfile), got:", files, err)
+	}
+}
+
+func TestFile_Entry(t *testing.T) {
+	for _, het := range []bool{false, true} {
+		archive := &testArchive{}
+		archive.add("plain", testData(100), fileFlagExists)
+		archive.add("crc", testData(5000), fileFlagExists|fileFlagCompress|fileFlagSectorCRC)
+		data := archive.build(t)
+		if het {
+			data = addHETAndBET(t, data)
+		}
+		mpq := openTestArchive(t, data)
+
+		for _, name := range []string{"plain", "crc"} {
+			file, err := mpq.FileInfo(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var index int
+			if het {
+				index, err = mpq.HETTable.Index(file.HashIndex)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if entry, _ := mpq.BETTable.Entry(file.BlockIndex); mpq.BETTable.Flags[file.FlagIndex] != entry.Flags {
+					t.Errorf("%s: Wrong flag index: %d", name, file.FlagIndex)
+				}
+			} else {
+				index = int(mpq.HashTable.Entries()[file.HashIndex].BlockIndex)
+			}
+			if index != file.BlockIndex {
+				t.Errorf("%s: Hash slot %d refers to %d, not %d", name, file.HashIndex, index, file.BlockIndex)
+			}
+
+			crc := name == "crc"
+			if file.IsCompressed != crc || file.HasSectorCRC != crc || file.IsEncrypted || file.IsSingleUnit || file.IsDeletionMarker {
+				t.Errorf("%s: Wrong decoded flags: %+v", name, file)
+			}
+		}
+
+		file, err := mpq.fileAt(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.HashIndex != -1 || file.BlockIndex != 0 {
+			t.Errorf("Wrong indexes of an unnamed file: %d %d", file.HashIndex, file.BlockIndex)
+		}
+	}
+}
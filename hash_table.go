@@ -31,18 +31,24 @@ type HashTableEntry struct {
 	BlockIndex uint32
 }
 
-func (m *MPQ) readHashTable(r io.Reader) error {
-	h := &HashTable{EntryCount: m.Header.HashTableSize}
-
-	size := uint64(m.Header.HashTableSize)
-	compressedSize := m.Header.HashTableSize64
+func (m *MPQ) readHashTable(position int64) error {
+	count, compressedSize := m.Header.HashTableSize, m.Header.HashTableSize64
+	recovering := m.recovering() && storedTable(count, hashTableEntrySize, compressedSize)
+	if recovering {
+		// Storm rounds the size up to a power of two and reads the entries
+		// that follow the declared ones.
+		count, compressedSize = m.recoverTableSize(position, powerOfTwo(count), hashTableEntrySize), 0
+	}
 
-	var err error
-	if h.Table, err = decryptDecompressTable(r, size, compressedSize, cryptKeyHashTable); err != nil {
+	table, err := m.readTable("Hash table", position, count, hashTableEntrySize, compressedSize, cryptKeyHashTable)
+	if err != nil {
 		return err
 	}
+	if recovering {
+		table = recoverHashTable(table, m.Header.HashTableSize)
+	}
 
-	m.HashTable = h
+	m.HashTable = &HashTable{EntryCount: len(table) / hashTableEntrySize, Table: table}
 	return nil
 }
 
@@ -75,15 +81,46 @@ func (h *HashTable) Entries() []HashTableEntry {
 	return entries
 }
 
+// readTable reads a hash or block table of count entries. Tables are only
+// compressed in v4 archives, which store their size in the header; a
+// compressedSize of 0 means the table is stored as is.
+func (m *MPQ) readTable(structure string, position int64, count, entrySize int, compressedSize uint64, key uint32) ([]byte, error) {
+	limits := m.limits()
+	if err := limits.checkEntries(structure, count); err != nil {
+		return nil, err
+	}
+
+	size := uint64(count) * uint64(entrySize)
+	if compressedSize == 0 {
+		compressedSize = size
+	}
+	if err := limits.checkTable(structure, size, compressedSize); err != nil {
+		return nil, err
+	}
+	if err := m.checkRange(structure, position, compressedSize); err != nil {
+		return nil, err
+	}
+
+	table, err := decryptDecompressTable(m.section(position, int64(compressedSize)), size, compressedSize, key)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(table)) < size {
+		return nil, corruptError(structure + " ended unexpectedly")
+	}
+
+	return table, nil
+}
+
 func decryptDecompressTable(r io.Reader, dataSize, compressedSize uint64, key uint32) ([]byte, error) {
 	crypted := make([]byte, compressedSize)
-	if _, err := r.Read(crypted); err != nil {
+	if _, err := io.ReadFull(r, crypted); err != nil {
 		return nil, err
 	}
 
 	decryptBlock(crypted, int(compressedSize), key)
 
-	if dataSize+extTableHeaderSize <= compressedSize {
+	if dataSize <= compressedSize {
 		return crypted, nil
 	}
 
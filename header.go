@@ -1,6 +1,7 @@
 package mpq
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 )
@@ -15,6 +16,10 @@ const (
 	headerUserData = 0x1B
 
 	digestSize = 16
+
+	// headerScanChunk is how much of the stream is read at once while
+	// searching for the archive header.
+	headerScanChunk = 64 << 10
 )
 
 var (
@@ -59,13 +64,114 @@ type Header struct {
 	MPQHeaderMD5    []byte
 }
 
+// findHeader searches the stream for the archive header and reads it and the
+// user data in front of it. Headers are searched at 512 byte boundaries as the
+// format requires first and at any position after that, in both cases no
+// further than Limits.MaxHeaderOffset into the stream.
+func (m *MPQ) findHeader() error {
+	for _, step := range []int64{512, 1} {
+		found, err := m.scanHeader(step)
+		if found || err != nil {
+			return err
+		}
+	}
+	return ErrHeaderNotFound
+}
+
+// scanHeader searches for the archive header at multiples of step.
+func (m *MPQ) scanHeader(step int64) (bool, error) {
+	limit := m.size - 4
+	if max := m.limits().MaxHeaderOffset; uint64(limit) > max {
+		limit = int64(max)
+	}
+
+	// Chunks overlap by 3 bytes so a signature can not be split between them.
+	buffer := make([]byte, headerScanChunk+3)
+	for start := int64(0); start <= limit; start += headerScanChunk {
+		n, err := m.readerAt.ReadAt(buffer, start)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		chunk := buffer[:n]
+
+		for i := 0; i+4 <= len(chunk); i++ {
+			j := bytes.Index(chunk[i:], headerMPQ)
+			if j < 0 {
+				break
+			}
+			i += j
+
+			position := start + int64(i)
+			if position > limit {
+				return false, nil
+			}
+			if position%step != 0 || i+4 > len(chunk) {
+				continue
+			}
+
+			if found, err := m.readHeaderAt(position, chunk[i+3]); found || err != nil {
+				return found, err
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// readHeaderAt reads the archive header, or the user data and the archive
+// header it points to, at position. It returns false if there is no header.
+func (m *MPQ) readHeaderAt(position int64, kind byte) (bool, error) {
+	switch kind {
+	case headerArchive:
+		m.offset = position
+		err := m.readArchiveHeader(io.NewSectionReader(m.readerAt, position+4, m.size-position-4))
+		if !m.recovering() {
+			if err != nil {
+				return false, m.formatError("header", 0, err)
+			}
+			return true, nil
+		}
+
+		// Fake headers are skipped in recovery mode.
+		if err == nil {
+			m.recoverHeader()
+			if m.plausibleHeader() {
+				return true, nil
+			}
+		}
+		m.offset, m.Header = 0, nil
+		return false, nil
+
+	case headerUserData:
+		if err := m.readUserData(io.NewSectionReader(m.readerAt, position+4, m.size-position-4), position); err != nil {
+			return false, &FormatError{Structure: "user data", Offset: position, Err: err}
+		}
+
+		// The user data points to the archive header, which is not
+		// necessarily at a 512 byte boundary.
+		headerPosition := position + int64(m.UserData.HeaderOffset)
+		var signature [4]byte
+		if headerPosition > position && headerPosition <= m.size-4 {
+			if _, err := m.readerAt.ReadAt(signature[:], headerPosition); err != nil && err != io.EOF {
+				return false, err
+			}
+			if bytes.Equal(signature[:3], headerMPQ) && signature[3] == headerArchive {
+				return m.readHeaderAt(headerPosition, headerArchive)
+			}
+		}
+		m.UserData = nil
+	}
+
+	return false, nil
+}
+
 func (m *MPQ) readArchiveHeader(r io.Reader) error {
 	var err error
 
 	header := &Header{}
 
 	buffer := make([]byte, 256)
-	if _, err = r.Read(buffer[:28]); err != nil {
+	if _, err = io.ReadFull(r, buffer[:28]); err != nil {
 		return err
 	}
 	header.HeaderSize = int(binary.LittleEndian.Uint32(buffer[:4]))
@@ -79,7 +185,7 @@ func (m *MPQ) readArchiveHeader(r io.Reader) error {
 	header.BlockTableSize = int(binary.LittleEndian.Uint32(buffer[24:28]))
 
 	if header.FormatVersion >= 1 { // Version >= 2
-		if _, err = r.Read(buffer[:12]); err != nil {
+		if _, err = io.ReadFull(r, buffer[:12]); err != nil {
 			return err
 		}
 		header.HiBlockTablePos = binary.LittleEndian.Uint64(buffer[:8])
@@ -88,7 +194,7 @@ func (m *MPQ) readArchiveHeader(r io.Reader) error {
 	}
 
 	if header.FormatVersion >= 2 { // Version >= 3
-		if _, err = r.Read(buffer[:24]); err != nil {
+		if _, err = io.ReadFull(r, buffer[:24]); err != nil {
 			return err
 		}
 		header.ArchiveSize = binary.LittleEndian.Uint64(buffer[:8])
@@ -97,7 +203,7 @@ func (m *MPQ) readArchiveHeader(r io.Reader) error {
 	}
 
 	if header.FormatVersion >= 3 { // Version >= 4
-		if _, err = r.Read(buffer[:140]); err != nil {
+		if _, err = io.ReadFull(r, buffer[:140]); err != nil {
 			return err
 		}
 
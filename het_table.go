@@ -16,6 +16,8 @@ const (
 
 var (
 	headerHETTable = []byte("HET\x1A")
+
+	errorHETTableBounds = corruptError("HET Table ended unexpectedly")
 )
 
 // HETTable from the MPQ Header.
@@ -39,27 +41,22 @@ type HETTable struct {
 
 	AndMask uint64
 	OrMask  uint64
+
+	indexes []uint
 }
 
-func (m *MPQ) readHETTable(r io.Reader) error {
+func (m *MPQ) readHETTable(position int64) error {
 	het := &HETTable{}
 
-	// Read Header Information
-	header := make([]byte, extTableHeaderSize)
-	if _, err := r.Read(header); err != nil {
-		return err
-	}
-
-	if bytes.Compare(headerHETTable, header[:4]) != 0 {
-		return fmt.Errorf("HET Table header not found, got: %02X", header[:4])
-	}
-	het.Version = int(binary.LittleEndian.Uint32(header[4:8]))
-	het.DataSize = int(binary.LittleEndian.Uint32(header[8:12]))
-
-	buffer, err := decryptDecompressExtTable(r, uint64(het.DataSize), m.Header.HETTableSize64, cryptKeyHashTable)
+	var buffer []byte
+	var err error
+	het.Version, het.DataSize, buffer, err = m.readExtTable("HET Table", position, headerHETTable, m.Header.HETTableSize64, cryptKeyHashTable)
 	if err != nil {
 		return err
 	}
+	if len(buffer) < 32 {
+		return errorHETTableBounds
+	}
 
 	het.TableSize = int(binary.LittleEndian.Uint32(buffer[0:4]))
 	het.EntryCount = int(binary.LittleEndian.Uint32(buffer[4:8]))
@@ -70,6 +67,14 @@ func (m *MPQ) readHETTable(r io.Reader) error {
 	het.IndexSize = int(binary.LittleEndian.Uint32(buffer[24:28]))
 	het.BlockTableSize = int(binary.LittleEndian.Uint32(buffer[28:32]))
 
+	limits := m.limits()
+	if err = limits.checkEntries("HET Table", het.EntryCount); err != nil {
+		return err
+	}
+	if het.HashEntrySize < 8 || het.HashEntrySize > 64 {
+		return corruptError(fmt.Sprintf("HET Table hash size is invalid: %d", het.HashEntrySize))
+	}
+
 	// Read Table Information
 	if het.HashEntrySize != 0x40 {
 		het.AndMask = uint64(1) << uint(het.HashEntrySize)
@@ -81,6 +86,9 @@ func (m *MPQ) readHETTable(r io.Reader) error {
 	if het.count == 0 {
 		het.count = (het.EntryCount * 4) / 3
 	}
+	if err = limits.checkEntries("HET Table", het.count); err != nil {
+		return err
+	}
 
 	maxValue := het.EntryCount
 	for maxValue > 0 {
@@ -88,16 +96,21 @@ func (m *MPQ) readHETTable(r io.Reader) error {
 		het.bitCount++
 	}
 
-	het.Hashes = make([]byte, het.count)
-	het.Indicies = make([]byte, (het.count*het.bitCount+7)/8)
-	for i := 0; i < len(het.Indicies); i++ {
-		het.Indicies[i] = 0xFF
+	// The sizes of the arrays are checked before they are allocated.
+	offset := 32
+	indiciesSize := (uint64(het.count)*uint64(het.bitCount) + 7) / 8
+	if err = limits.checkTable("HET Table", uint64(het.count)+indiciesSize, uint64(het.count)+indiciesSize); err != nil {
+		return err
+	}
+	if uint64(len(buffer)-offset) < uint64(het.count)+indiciesSize {
+		return errorHETTableBounds
 	}
 
-	offset := 32
+	het.Hashes = make([]byte, het.count)
+	het.Indicies = make([]byte, indiciesSize)
 	copy(het.Hashes, buffer[offset:offset+het.count])
 	offset += het.count
-	copy(het.Indicies, buffer[offset:offset+len(het.Indicies)])
+	copy(het.Indicies, buffer[offset:])
 
 	m.HETTable = het
 	return nil
@@ -105,6 +118,9 @@ func (m *MPQ) readHETTable(r io.Reader) error {
 
 // Indexes reads the bit array from the het.Indicies and turns it into a uint array.
 func (h *HETTable) Indexes() ([]uint, error) {
+	if h.indexes != nil {
+		return h.indexes, nil
+	}
 	ret := make([]uint, h.count)
 	b := bitstream.New(bytes.NewBuffer(h.Indicies))
 
@@ -112,18 +128,74 @@ func (h *HETTable) Indexes() ([]uint, error) {
 	var err error
 	for i := 0; i < h.count; i++ {
 		if val, err = b.Bits(h.bitCount); err != nil {
-			return nil, errors.New("HET Table ended unexpectedly")
+			return nil, errorHETTableBounds
 		}
 
 		ret[i] = uint(val)
 	}
 
+	h.indexes = ret
 	return ret, nil
 }
 
+// Index reads the BET table index stored in slot i without decoding the rest
+// of the bit array.
+func (h *HETTable) Index(i int) (int, error) {
+	if i < 0 || i >= h.count {
+		return 0, errors.New("HET Table index out of range")
+	}
+
+	val, err := readBits(h.Indicies, i*h.bitCount, h.bitCount)
+	if err != nil {
+		return 0, errorHETTableBounds
+	}
+	return int(val), nil
+}
+
+// readExtTable reads the header and the decrypted, decompressed data of a HET
+// or BET table. v3 archives do not store the size of the table, it is then
+// assumed to be stored as is.
+func (m *MPQ) readExtTable(structure string, position int64, signature []byte, compressedSize uint64, key uint32) (int, int, []byte, error) {
+	if err := m.checkRange(structure, position, extTableHeaderSize); err != nil {
+		return 0, 0, nil, err
+	}
+
+	header := make([]byte, extTableHeaderSize)
+	if err := m.readAt(header, position); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if !bytes.Equal(signature, header[:4]) {
+		return 0, 0, nil, corruptError(fmt.Sprintf("%s header not found, got: %02X", structure, header[:4]))
+	}
+	version := int(binary.LittleEndian.Uint32(header[4:8]))
+	dataSize := uint64(binary.LittleEndian.Uint32(header[8:12]))
+
+	if compressedSize == 0 {
+		compressedSize = dataSize + extTableHeaderSize
+	}
+	if compressedSize < extTableHeaderSize {
+		return 0, 0, nil, corruptError(fmt.Sprintf("%s size is invalid: %d", structure, compressedSize))
+	}
+	if err := m.limits().checkTable(structure, dataSize, compressedSize-extTableHeaderSize); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := m.checkRange(structure, position, compressedSize); err != nil {
+		return 0, 0, nil, err
+	}
+
+	r := m.section(position+extTableHeaderSize, int64(compressedSize-extTableHeaderSize))
+	data, err := decryptDecompressExtTable(r, dataSize, compressedSize, key)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return version, int(dataSize), data, nil
+}
+
 func decryptDecompressExtTable(r io.Reader, dataSize, compressedSize uint64, key uint32) ([]byte, error) {
 	crypted := make([]byte, compressedSize-extTableHeaderSize)
-	if _, err := r.Read(crypted); err != nil {
+	if _, err := io.ReadFull(r, crypted); err != nil {
 		return nil, err
 	}
 
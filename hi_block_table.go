@@ -13,12 +13,20 @@ type HiBlockTable struct {
 	Table []uint16
 }
 
-func (m *MPQ) readHiBlockTable(r io.Reader) error {
+func (m *MPQ) readHiBlockTable(position int64) error {
 	h := &HiBlockTable{}
 
+	if err := m.limits().checkEntries("Hi-block table", m.Header.BlockTableSize); err != nil {
+		return err
+	}
+	size := uint64(m.Header.BlockTableSize) * 2
+	if err := m.checkRange("Hi-block table", position, size); err != nil {
+		return err
+	}
+
 	offset := 0
-	buffer := make([]byte, m.Header.BlockTableSize*2)
-	if _, err := r.Read(buffer); err != nil {
+	buffer := make([]byte, size)
+	if _, err := io.ReadFull(m.section(position, int64(size)), buffer); err != nil {
 		return err
 	}
 
@@ -0,0 +1,277 @@
+package mpq
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const (
+	defaultHTTPBlockSize = 64 << 10
+	defaultHTTPCacheSize = 64 << 20
+)
+
+// ErrRangeNotSupported occurs when a server answers a Range request with
+// something other than the requested range.
+var ErrRangeNotSupported = errors.New("Server does not support range requests")
+
+// HTTPReader reads a file over HTTP with Range requests. It implements
+// io.ReaderAt, for which it is safe to use from several goroutines, and
+// io.ReadSeeker, so an archive can be opened with OpenReader and only the parts
+// of it that are read are downloaded.
+//
+// Data is fetched in blocks that are kept in a cache. A read of several blocks
+// that are not cached fetches them with one request, and reads of a block that
+// is being fetched wait for that request.
+type HTTPReader struct {
+	client    *http.Client
+	url       string
+	size      int64
+	blockSize int64
+	maxBlocks int
+
+	mutex  sync.Mutex
+	blocks map[int64]*list.Element
+	lru    *list.List
+
+	position int64
+}
+
+// httpBlock is a block of the file that is cached or being fetched.
+type httpBlock struct {
+	index int64
+	done  chan struct{}
+	data  []byte
+	err   error
+}
+
+// NewHTTPReader creates a reader for the file at url, which it requests to learn
+// the size of the file. Blocks of blockSize bytes are fetched and up to cacheSize
+// bytes of them are cached, either is 64KB and 64MB if it is zero. A nil client
+// uses http.DefaultClient.
+func NewHTTPReader(client *http.Client, url string, blockSize, cacheSize int64) (*HTTPReader, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if blockSize <= 0 {
+		blockSize = defaultHTTPBlockSize
+	}
+	if cacheSize <= 0 {
+		cacheSize = defaultHTTPCacheSize
+	}
+
+	h := &HTTPReader{
+		client:    client,
+		url:       url,
+		blockSize: blockSize,
+		maxBlocks: int(cacheSize / blockSize),
+		blocks:    make(map[int64]*list.Element),
+		lru:       list.New(),
+	}
+	if h.maxBlocks < 1 {
+		h.maxBlocks = 1
+	}
+
+	// The first block is requested without knowing the size, the response
+	// tells it.
+	block := h.add(0)
+	h.fetch([]*httpBlock{block})
+	if block.err != nil {
+		return nil, block.err
+	}
+
+	return h, nil
+}
+
+// Size of the file.
+func (h *HTTPReader) Size() int64 {
+	return h.size
+}
+
+// ReadAt reads len(buffer) bytes at offset.
+func (h *HTTPReader) ReadAt(buffer []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("Negative offset")
+	}
+	if offset >= h.size {
+		return 0, io.EOF
+	}
+
+	end := offset + int64(len(buffer))
+	if end > h.size {
+		end = h.size
+	}
+
+	n := 0
+	for _, block := range h.get(offset/h.blockSize, (end-1)/h.blockSize) {
+		<-block.done
+		if block.err != nil {
+			return n, block.err
+		}
+
+		position := offset + int64(n) - block.index*h.blockSize
+		if position >= int64(len(block.data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(buffer[n:end-offset], block.data[position:])
+	}
+
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Read reads from the current position.
+func (h *HTTPReader) Read(buffer []byte) (int, error) {
+	n, err := h.ReadAt(buffer, h.position)
+	h.position += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek sets the position of Read.
+func (h *HTTPReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += h.position
+	case io.SeekEnd:
+		offset += h.size
+	}
+	if offset < 0 {
+		return 0, errors.New("Negative position")
+	}
+
+	h.position = offset
+	return offset, nil
+}
+
+// get returns the blocks from first to last, fetching the ones that are not
+// cached. Each run of blocks that are not cached is fetched with one request.
+func (h *HTTPReader) get(first, last int64) []*httpBlock {
+	blocks := make([]*httpBlock, 0, last-first+1)
+	var runs [][]*httpBlock
+	var run []*httpBlock
+
+	h.mutex.Lock()
+	for i := first; i <= last; i++ {
+		if element, ok := h.blocks[i]; ok {
+			h.lru.MoveToFront(element)
+			blocks = append(blocks, element.Value.(*httpBlock))
+			if run != nil {
+				runs, run = append(runs, run), nil
+			}
+			continue
+		}
+
+		block := h.addLocked(i)
+		blocks = append(blocks, block)
+		run = append(run, block)
+	}
+	if run != nil {
+		runs = append(runs, run)
+	}
+	h.mutex.Unlock()
+
+	for _, run := range runs {
+		h.fetch(run)
+	}
+	return blocks
+}
+
+// add puts a block that is yet to be fetched into the cache.
+func (h *HTTPReader) add(index int64) *httpBlock {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.addLocked(index)
+}
+
+func (h *HTTPReader) addLocked(index int64) *httpBlock {
+	block := &httpBlock{index: index, done: make(chan struct{})}
+	h.blocks[index] = h.lru.PushFront(block)
+
+	for h.lru.Len() > h.maxBlocks {
+		oldest := h.lru.Back()
+		h.lru.Remove(oldest)
+		delete(h.blocks, oldest.Value.(*httpBlock).index)
+	}
+	return block
+}
+
+// remove takes a block that could not be fetched out of the cache, so it is
+// requested again by the next read.
+func (h *HTTPReader) remove(block *httpBlock) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if element, ok := h.blocks[block.index]; ok && element.Value == block {
+		h.lru.Remove(element)
+		delete(h.blocks, block.index)
+	}
+}
+
+// fetch requests the consecutive blocks of run and completes them.
+func (h *HTTPReader) fetch(run []*httpBlock) {
+	start := run[0].index * h.blockSize
+	end := (run[len(run)-1].index+1)*h.blockSize - 1
+	if h.size != 0 && end >= h.size {
+		end = h.size - 1
+	}
+
+	data, err := h.request(start, end)
+	for _, block := range run {
+		if err != nil {
+			block.err = err
+			h.remove(block)
+		} else {
+			offset := block.index*h.blockSize - start
+			if offset > int64(len(data)) {
+				offset = int64(len(data))
+			}
+			size := h.blockSize
+			if size > int64(len(data))-offset {
+				size = int64(len(data)) - offset
+			}
+			block.data = data[offset : offset+size]
+		}
+		close(block.done)
+	}
+}
+
+// request fetches the bytes from start to end, inclusive. The response to the
+// first request sets the size of the file.
+func (h *HTTPReader) request(start, end int64) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	response, err := h.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("%s: %s: %w", h.url, response.Status, ErrRangeNotSupported)
+	}
+
+	var first, last, size int64
+	if _, err = fmt.Sscanf(response.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil || first != start || last < first || last > end {
+		return nil, fmt.Errorf("%s: Content-Range %q: %w", h.url, response.Header.Get("Content-Range"), ErrRangeNotSupported)
+	}
+	if h.size == 0 {
+		h.size = size
+	}
+
+	data := make([]byte, last-first+1)
+	if _, err = io.ReadFull(response.Body, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,152 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// rangeServer serves data with support for Range requests and counts the
+// requests and the bytes it sends.
+type rangeServer struct {
+	*httptest.Server
+	requests int64
+	sent     int64
+}
+
+func newRangeServer(data []byte, gate chan struct{}) *rangeServer {
+	server := &rangeServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&server.requests, 1)
+		if gate != nil {
+			<-gate
+		}
+		http.ServeContent(countingWriter{w, &server.sent}, r, "archive.mpq", time.Time{}, bytes.NewReader(data))
+	}))
+	return server
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	count *int64
+}
+
+func (c countingWriter) Write(buffer []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(buffer)
+	atomic.AddInt64(c.count, int64(n))
+	return n, err
+}
+
+func TestHTTPReader_OpenReader(t *testing.T) {
+	contents := testData(50000)
+	data := (&testArchive{}).
+		add("small", testData(300), fileFlagExists|fileFlagCompress).
+		add("large", contents, fileFlagExists|fileFlagCompress).
+		add("other", testData(200000), fileFlagExists).
+		build(t)
+
+	server := newRangeServer(data, nil)
+	defer server.Close()
+
+	reader, err := NewHTTPReader(nil, server.URL, 4096, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.Size() != int64(len(data)) {
+		t.Error("Wrong size:", reader.Size())
+	}
+
+	mpq, err := OpenReader(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, err := mpq.Files(); err != nil || len(files) != 4 {
+		t.Errorf("Wrong files: %v %v", files, err)
+	}
+
+	file, err := mpq.Open("large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, contents) {
+		t.Error("Wrong contents.")
+	}
+
+	if sent := atomic.LoadInt64(&server.sent); sent > int64(len(data))/2 {
+		t.Errorf("Downloaded %d of %d bytes", sent, len(data))
+	}
+}
+
+func TestHTTPReader_Blocks(t *testing.T) {
+	data := testData(100000)
+	gate := make(chan struct{}, 1)
+	server := newRangeServer(data, gate)
+	defer server.Close()
+
+	gate <- struct{}{}
+	reader, err := NewHTTPReader(server.Client(), server.URL, 1000, 10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ten blocks are fetched with one request, reads of the same blocks at
+	// the same time wait for it.
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			buffer := make([]byte, 9500)
+			if n, err := reader.ReadAt(buffer, 10200); err != nil || n != len(buffer) || !bytes.Equal(buffer, data[10200:19700]) {
+				t.Errorf("Wrong read: %d %v", n, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	gate <- struct{}{}
+	wait.Wait()
+
+	if requests := atomic.LoadInt64(&server.requests); requests != 2 {
+		t.Error("Expected 2 requests, got:", requests)
+	}
+
+	close(gate)
+	buffer := make([]byte, 100)
+	if _, err = reader.ReadAt(buffer, 15000); err != nil || atomic.LoadInt64(&server.requests) != 2 {
+		t.Error("Cached block was fetched again:", err)
+	}
+	if n, err := reader.ReadAt(buffer, int64(len(data))-50); n != 50 || err == nil {
+		t.Errorf("Expected a short read at the end, got: %d %v", n, err)
+	}
+
+	// Reading all blocks evicts the first ones from the cache.
+	all, err := ioutil.ReadAll(reader)
+	if err != nil || !bytes.Equal(all, data) {
+		t.Error("Wrong contents:", err)
+	}
+	requests := atomic.LoadInt64(&server.requests)
+	if _, err = reader.ReadAt(buffer, 0); err != nil || atomic.LoadInt64(&server.requests) != requests+1 {
+		t.Error("Evicted block was not fetched again:", err)
+	}
+}
+
+func TestHTTPReader_NoRange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("MPQ"))
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPReader(nil, server.URL, 0, 0); !errors.Is(err, ErrRangeNotSupported) {
+		t.Error("Expected ErrRangeNotSupported, got:", err)
+	}
+}
@@ -0,0 +1,177 @@
+package mpq
+
+import (
+	"sync"
+)
+
+// lookupIndex maps the name hashes of a HET or hash table to the slots that
+// hold them, so a lookup does not have to decode or probe the table.
+type lookupIndex struct {
+	// first is the lowest slot holding a key, next links it to the next one.
+	first map[uint64]int32
+	next  []int32
+	// run is the amount of used slots from a slot up to the next free one,
+	// wrapping around the end of the table.
+	run []int32
+}
+
+// These are the states a slot of a table can be in.
+const (
+	slotFree = iota
+	// slotUsed is a deleted entry, probes continue past it.
+	slotUsed
+	slotKeyed
+)
+
+// newLookupIndex builds an index over count slots. key returns the state of a
+// slot and the key it holds.
+func newLookupIndex(count int, key func(slot int) (uint64, int, error)) (*lookupIndex, error) {
+	l := &lookupIndex{
+		first: make(map[uint64]int32, count),
+		next:  make([]int32, count),
+		run:   make([]int32, count),
+	}
+
+	used := make([]bool, count)
+	for slot := count - 1; slot >= 0; slot-- {
+		k, state, err := key(slot)
+		if err != nil {
+			return nil, err
+		}
+
+		used[slot] = state != slotFree
+		l.next[slot] = -1
+		if state != slotKeyed {
+			continue
+		}
+
+		if next, ok := l.first[k]; ok {
+			l.next[slot] = next
+		}
+		l.first[k] = int32(slot)
+	}
+
+	// Two passes from the end so runs wrap around to the start.
+	var run int32
+	for pass := 0; pass < 2; pass++ {
+		for slot := count - 1; slot >= 0; slot-- {
+			if !used[slot] {
+				run = 0
+			} else if run < int32(count) {
+				run++
+			}
+			l.run[slot] = run
+		}
+	}
+
+	return l, nil
+}
+
+// find returns the slot holding key that a probe from start reaches, the
+// first one or, if last is set, the last one. It returns -1 if there is none.
+func (l *lookupIndex) find(key uint64, start int, last bool) int {
+	count := len(l.run)
+	if count == 0 {
+		return -1
+	}
+	start %= count
+
+	slot, ok := l.first[key]
+	if !ok {
+		return -1
+	}
+
+	found, distance := -1, 0
+	for ; slot >= 0; slot = l.next[slot] {
+		d := (int(slot) - start + count) % count
+		if d >= int(l.run[start]) {
+			continue
+		}
+		if found < 0 || (last && d > distance) || (!last && d < distance) {
+			found, distance = int(slot), d
+		}
+	}
+
+	return found
+}
+
+// tableIndex is a lookupIndex that is built the first time it is needed.
+type tableIndex struct {
+	once  sync.Once
+	index *lookupIndex
+	err   error
+}
+
+func (t *tableIndex) get(build func() (*lookupIndex, error)) (*lookupIndex, error) {
+	t.once.Do(func() {
+		t.index, t.err = build()
+	})
+	return t.index, t.err
+}
+
+// hetIndex returns the lookup index of the HET table.
+func (m *MPQ) hetIndex() (*lookupIndex, error) {
+	return m.hetLookup.get(func() (*lookupIndex, error) {
+		het, bet := m.HETTable, m.BETTable
+		shift := uint(het.HashEntrySize - 8)
+		return newLookupIndex(len(het.Hashes), func(slot int) (uint64, int, error) {
+			if het.Hashes[slot] == 0 {
+				return 0, slotFree, nil
+			}
+
+			index, err := het.Index(slot)
+			if err != nil {
+				return 0, slotFree, err
+			}
+			if index >= bet.EntryCount {
+				return 0, slotUsed, nil
+			}
+			nameHash2, err := bet.NameHash2(index)
+			if err != nil {
+				return 0, slotFree, err
+			}
+			return uint64(het.Hashes[slot])<<shift | nameHash2, slotKeyed, nil
+		})
+	})
+}
+
+// hashIndex returns the lookup index of the hash table.
+func (m *MPQ) hashIndex() (*lookupIndex, error) {
+	return m.hashLookup.get(func() (*lookupIndex, error) {
+		entries := m.HashTable.Entries()
+		return newLookupIndex(len(entries), func(slot int) (uint64, int, error) {
+			entry := &entries[slot]
+			switch entry.BlockIndex {
+			case hashTableEmpty:
+				return 0, slotFree, nil
+			case hashTableDeleted:
+				return 0, slotUsed, nil
+			}
+			return uint64(entry.Name1)<<32 | uint64(entry.Name2), slotKeyed, nil
+		})
+	})
+}
+
+var errorBitsBounds = corruptError("Bit array ended unexpectedly")
+
+// readBits reads count bits, least significant first, starting at bit offset
+// of a bit array.
+func readBits(data []byte, offset, count int) (uint64, error) {
+	if count > 64 || offset < 0 || count < 0 || offset+count > len(data)*8 {
+		return 0, errorBitsBounds
+	}
+
+	var value uint64
+	for read := 0; read < count; {
+		b := uint64(data[(offset+read)/8] >> uint((offset+read)%8))
+		n := 8 - (offset+read)%8
+		if n > count-read {
+			n = count - read
+		}
+
+		value |= (b & (1<<uint(n) - 1)) << uint(read)
+		read += n
+	}
+
+	return value, nil
+}
@@ -0,0 +1,303 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// addHETAndBET appends HET and BET tables for the files of a test archive,
+// which must all be named by its (listfile), and points its header at them.
+func addHETAndBET(t testing.TB, data []byte) []byte {
+	mpq := openTestArchive(t, data)
+	names := make([]string, mpq.BlockTable.EntryCount)
+	for name, file := range mpq.FileList {
+		names[file.BlockIndex] = name
+	}
+	for i, name := range names {
+		if name == "" {
+			t.Fatalf("Block %d has no name.", i)
+		}
+	}
+
+	position := int(binary.LittleEndian.Uint32(data[20:24]))
+	blockTable := append([]byte(nil), data[position:position+len(names)*blockTableEntrySize]...)
+	decryptBlock(blockTable, len(blockTable), cryptKeyBlockTable)
+	het, bet := buildHETAndBET(names, blockTable)
+
+	data = append(data, het...)
+	data = append(data, bet...)
+	binary.LittleEndian.PutUint32(data[8:12], uint32(len(data)))
+	binary.LittleEndian.PutUint64(data[44:52], uint64(len(data)))
+	binary.LittleEndian.PutUint64(data[52:60], uint64(len(data)-len(bet)))
+	binary.LittleEndian.PutUint64(data[60:68], uint64(len(data)-len(bet)-len(het)))
+	binary.LittleEndian.PutUint64(data[92:100], uint64(len(het)))
+	binary.LittleEndian.PutUint64(data[100:108], uint64(len(bet)))
+	return data
+}
+
+// buildHETAndBET creates encrypted HET and BET tables, with 64-bit name
+// hashes, for the files named by names whose block table entries are in the
+// (unencrypted) blockTable.
+func buildHETAndBET(names []string, blockTable []byte) ([]byte, []byte) {
+	const hashEntrySize = 64
+	count := len(names)
+	slots := count*4/3 + 1
+
+	indexBits := 0
+	for max := count; max > 0; max >>= 1 {
+		indexBits++
+	}
+
+	hashes := make([]byte, slots)
+	indexes := make([]byte, (slots*indexBits+7)/8)
+	nameHashes := make([]uint64, count)
+	for i, name := range names {
+		hash := jenkins2(name) | 1<<(hashEntrySize-1)
+		nameHashes[i] = hash & (1<<(hashEntrySize-8) - 1)
+
+		slot := int(hash % uint64(slots))
+		for hashes[slot] != 0 {
+			slot = (slot + 1) % slots
+		}
+		hashes[slot] = byte(hash >> (hashEntrySize - 8))
+		writeBits(indexes, slot*indexBits, indexBits, uint64(i))
+	}
+
+	het := make([]byte, 32)
+	binary.LittleEndian.PutUint32(het[0:4], uint32(32+len(hashes)+len(indexes)))
+	binary.LittleEndian.PutUint32(het[4:8], uint32(count))
+	binary.LittleEndian.PutUint32(het[8:12], uint32(slots))
+	binary.LittleEndian.PutUint32(het[12:16], hashEntrySize)
+	binary.LittleEndian.PutUint32(het[16:20], uint32(indexBits))
+	binary.LittleEndian.PutUint32(het[24:28], uint32(indexBits))
+	binary.LittleEndian.PutUint32(het[28:32], uint32(len(indexes)))
+	het = append(append(het, hashes...), indexes...)
+
+	var flags []uint32
+	flagIndexes := make(map[uint32]int)
+	for i := range names {
+		flag := binary.LittleEndian.Uint32(blockTable[i*16+12:])
+		if _, ok := flagIndexes[flag]; !ok {
+			flagIndexes[flag] = len(flags)
+			flags = append(flags, flag)
+		}
+	}
+
+	const fieldBits = 32
+	flagBits := 0
+	for max := len(flags) - 1; max > 0; max >>= 1 {
+		flagBits++
+	}
+	entryBits := fieldBits*3 + flagBits
+	hashBits := hashEntrySize - 8
+
+	entries := make([]byte, (count*entryBits+7)/8)
+	nameBits := make([]byte, (count*hashBits+7)/8)
+	for i := range names {
+		block := blockTable[i*16:]
+		offset := i * entryBits
+		writeBits(entries, offset, fieldBits, uint64(binary.LittleEndian.Uint32(block[0:4])))
+		writeBits(entries, offset+fieldBits, fieldBits, uint64(binary.LittleEndian.Uint32(block[8:12])))
+		writeBits(entries, offset+fieldBits*2, fieldBits, uint64(binary.LittleEndian.Uint32(block[4:8])))
+		writeBits(entries, offset+fieldBits*3, flagBits, uint64(flagIndexes[binary.LittleEndian.Uint32(block[12:16])]))
+		writeBits(nameBits, i*hashBits, hashBits, nameHashes[i])
+	}
+
+	bet := make([]byte, 76+len(flags)*4)
+	for i, v := range []int{
+		0, count, 0x10, entryBits,
+		0, fieldBits, fieldBits * 2, fieldBits * 3, entryBits,
+		fieldBits, fieldBits, fieldBits, flagBits, 0,
+		hashBits, 0, hashBits, len(nameBits),
+		len(flags),
+	} {
+		binary.LittleEndian.PutUint32(bet[i*4:], uint32(v))
+	}
+	for i, flag := range flags {
+		binary.LittleEndian.PutUint32(bet[76+i*4:], flag)
+	}
+	bet = append(append(bet, entries...), nameBits...)
+	binary.LittleEndian.PutUint32(bet[0:4], uint32(len(bet)))
+
+	return extTable(headerHETTable, het, cryptKeyHashTable), extTable(headerBETTable, bet, cryptKeyBlockTable)
+}
+
+// extTable puts the header of a HET or BET table in front of its encrypted data.
+func extTable(signature, data []byte, key uint32) []byte {
+	table := make([]byte, extTableHeaderSize, extTableHeaderSize+len(data))
+	copy(table, signature)
+	binary.LittleEndian.PutUint32(table[4:8], 1)
+	binary.LittleEndian.PutUint32(table[8:12], uint32(len(data)))
+
+	encryptBlock(data, key)
+	return append(table, data...)
+}
+
+// writeBits is the inverse of readBits.
+func writeBits(data []byte, offset, count int, value uint64) {
+	for i := 0; i < count; i++ {
+		if value&(1<<uint(i)) != 0 {
+			data[(offset+i)/8] |= 1 << uint((offset+i)%8)
+		}
+	}
+}
+
+func TestReadBits(t *testing.T) {
+	data := make([]byte, 16)
+	writeBits(data, 3, 7, 0x55)
+	writeBits(data, 10, 64, 0xFEDCBA9876543210)
+	writeBits(data, 74, 13, 0x1ABC)
+
+	for _, test := range []struct {
+		offset, count int
+		expected      uint64
+	}{
+		{3, 7, 0x55},
+		{10, 64, 0xFEDCBA9876543210},
+		{74, 13, 0x1ABC},
+		{0, 0, 0},
+	} {
+		val, err := readBits(data, test.offset, test.count)
+		if err != nil {
+			t.Error(test.offset, err)
+		} else if val != test.expected {
+			t.Errorf("%d> Wrong value: %X", test.offset, val)
+		}
+	}
+
+	if _, err := readBits(data, 120, 9); err == nil {
+		t.Error("Expected an error reading past the end.")
+	}
+}
+
+func TestLookupIndex(t *testing.T) {
+	// Slots 6, 7, 0 and 1 form a chain that wraps around, slot 3 is deleted.
+	type slot struct {
+		key   uint64
+		state int
+	}
+	slots := []slot{{1, slotKeyed}, {2, slotKeyed}, {0, slotFree}, {0, slotUsed}, {2, slotKeyed}, {0, slotFree}, {3, slotKeyed}, {2, slotKeyed}}
+
+	index, err := newLookupIndex(len(slots), func(i int) (uint64, int, error) {
+		return slots[i].key, slots[i].state, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		key   uint64
+		start int
+		last  bool
+		slot  int
+	}{
+		{1, 6, false, 0},
+		{2, 6, false, 7},
+		{2, 6, true, 1},
+		{2, 3, false, 4},
+		{2, 5, false, -1},
+		{3, 0, false, -1},
+		{4, 6, false, -1},
+	} {
+		if slot := index.find(test.key, test.start, test.last); slot != test.slot {
+			t.Errorf("%d from %d> Wrong slot: %d, expected: %d", test.key, test.start, slot, test.slot)
+		}
+	}
+}
+
+func TestFile_FromHETAndBETIndex(t *testing.T) {
+	archive := &testArchive{}
+	for i := 0; i < 100; i++ {
+		archive.add(fmt.Sprintf(`dir\file%03d`, i), []byte(fmt.Sprint(i)), fileFlagExists)
+	}
+	mpq := openTestArchive(t, addHETAndBET(t, archive.build(t)))
+
+	if mpq.HETTable == nil || mpq.BETTable == nil {
+		t.Fatal("Expected HET and BET tables.")
+	}
+
+	entries, err := mpq.BETTable.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range entries {
+		entry, err := mpq.BETTable.Entry(i)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if entry != expected {
+			t.Errorf("%d> Wrong entry: %+v, expected: %+v", i, entry, expected)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf(`dir\file%03d`, i)
+		reader, err := mpq.Open(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(contents, []byte(fmt.Sprint(i))) {
+			t.Errorf("%s> Wrong contents: %s", name, contents)
+		}
+	}
+
+	if _, err = mpq.FileInfo("missing"); err != ErrFileNotFound {
+		t.Error("Expected ErrFileNotFound, got:", err)
+	}
+}
+
+var benchmarkArchives = map[bool][]byte{}
+
+// benchmarkArchive builds an archive with a million files once per table type.
+func benchmarkArchive(b *testing.B, het bool) []byte {
+	if archive, ok := benchmarkArchives[het]; ok {
+		return archive
+	}
+
+	archive := &testArchive{}
+	for i := 0; i < 1000000; i++ {
+		archive.add(fmt.Sprintf(`dir\file%07d`, i), []byte{byte(i)}, fileFlagExists)
+	}
+	benchmarkArchives[het] = archive.build(b)
+	if het {
+		benchmarkArchives[het] = addHETAndBET(b, benchmarkArchives[het])
+	}
+	return benchmarkArchives[het]
+}
+
+func benchmarkOpen(b *testing.B, het bool) {
+	archive := benchmarkArchive(b, het)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := OpenBytes(archive); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkFileInfo(b *testing.B, het bool) {
+	mpq, err := OpenBytes(benchmarkArchive(b, het))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := mpq.FileInfo(fmt.Sprintf(`dir\file%07d`, i%1000000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpen_HashTable1M(b *testing.B)     { benchmarkOpen(b, false) }
+func BenchmarkOpen_HETTable1M(b *testing.B)      { benchmarkOpen(b, true) }
+func BenchmarkFileInfo_HashTable1M(b *testing.B) { benchmarkFileInfo(b, false) }
+func BenchmarkFileInfo_HETTable1M(b *testing.B)  { benchmarkFileInfo(b, true) }
@@ -0,0 +1,109 @@
+package mpq
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrLimitExceeded occurs when part of an archive is larger than the Limits
+// it was opened with allow.
+var ErrLimitExceeded = errors.New("Archive exceeds limits")
+
+// Limits bounds the memory used to read an archive, since the sizes stored in
+// an archive can not be trusted.
+type Limits struct {
+	// MaxTableSize is the largest size in bytes of a table, both as stored
+	// and after decompression.
+	MaxTableSize uint64
+	// MaxEntries is the largest amount of entries in a table.
+	MaxEntries int
+	// MaxFileSize is the largest size of a file after decompression.
+	MaxFileSize uint64
+	// MaxCompressionRatio is the largest ratio between the size of a sector
+	// or table after decompression and its size as stored. A single unit
+	// file is one sector.
+	MaxCompressionRatio uint64
+	// MaxHeaderOffset is how far into a stream the archive header is
+	// searched for.
+	MaxHeaderOffset uint64
+}
+
+// DefaultLimits are the limits used when an archive is opened without WithLimits.
+var DefaultLimits = Limits{
+	MaxTableSize:        256 << 20,
+	MaxEntries:          1 << 24,
+	MaxFileSize:         4 << 30,
+	MaxCompressionRatio: 4096,
+	MaxHeaderOffset:     128 << 20,
+}
+
+// WithLimits sets the limits used to read the archive. Fields that are zero
+// keep their value from DefaultLimits.
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		if limits.MaxTableSize != 0 {
+			o.limits.MaxTableSize = limits.MaxTableSize
+		}
+		if limits.MaxEntries != 0 {
+			o.limits.MaxEntries = limits.MaxEntries
+		}
+		if limits.MaxFileSize != 0 {
+			o.limits.MaxFileSize = limits.MaxFileSize
+		}
+		if limits.MaxCompressionRatio != 0 {
+			o.limits.MaxCompressionRatio = limits.MaxCompressionRatio
+		}
+		if limits.MaxHeaderOffset != 0 {
+			o.limits.MaxHeaderOffset = limits.MaxHeaderOffset
+		}
+	}
+}
+
+// limits returns the limits the archive was opened with.
+func (m *MPQ) limits() *Limits {
+	if m.opts == nil {
+		return &DefaultLimits
+	}
+	return &m.opts.limits
+}
+
+// checkEntries checks the amount of entries in a table.
+func (l *Limits) checkEntries(structure string, count int) error {
+	if count < 0 || count > l.MaxEntries {
+		return fmt.Errorf("%s has %d entries: %w", structure, count, ErrLimitExceeded)
+	}
+	return nil
+}
+
+// checkTable checks the size of a table before and after decompression.
+func (l *Limits) checkTable(structure string, size, compressedSize uint64) error {
+	if size > l.MaxTableSize || compressedSize > l.MaxTableSize {
+		return fmt.Errorf("%s is %d bytes: %w", structure, size, ErrLimitExceeded)
+	}
+	return l.checkRatio(structure, size, compressedSize)
+}
+
+// checkFile checks the size of a file.
+func (l *Limits) checkFile(file *File) error {
+	if file.FileSize > l.MaxFileSize {
+		return fmt.Errorf("File is %d bytes: %w", file.FileSize, ErrLimitExceeded)
+	}
+	return nil
+}
+
+func (l *Limits) checkRatio(structure string, size, compressedSize uint64) error {
+	if size > compressedSize && (compressedSize == 0 || size/compressedSize > l.MaxCompressionRatio) {
+		return fmt.Errorf("%s decompresses from %d to %d bytes: %w", structure, compressedSize, size, ErrLimitExceeded)
+	}
+	return nil
+}
+
+// checkRange makes sure size bytes at position, relative to the archive
+// start, are within the stream.
+func (m *MPQ) checkRange(structure string, position int64, size uint64) error {
+	start := m.offset + position
+	if (position < 0 && !m.recovering()) || start < 0 || start > m.size || size > uint64(m.size-start) {
+		return corruptError(fmt.Sprintf("%s at %d is outside of the archive", structure, position))
+	}
+	return nil
+}
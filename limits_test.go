@@ -0,0 +1,167 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	archive := &testArchive{}
+	for i := 0; i < 10; i++ {
+		archive.add(fmt.Sprintf("file%d", i), testData(1000), fileFlagExists)
+	}
+	archive.add("zeros", make([]byte, 100000), fileFlagExists|fileFlagCompress|fileFlagSingleUnit)
+	data := archive.build(t)
+
+	if _, err := OpenBytes(data, WithLimits(Limits{MaxEntries: 4})); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("Expected ErrLimitExceeded for the entries, got:", err)
+	}
+	if _, err := OpenBytes(data, WithLimits(Limits{MaxTableSize: 64})); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("Expected ErrLimitExceeded for the table size, got:", err)
+	}
+
+	mpq, err := OpenBytes(data, WithLimits(Limits{MaxFileSize: 500}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mpq.Open("file0"); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("Expected ErrLimitExceeded for the file size, got:", err)
+	}
+
+	mpq, err = OpenBytes(data, WithLimits(Limits{MaxCompressionRatio: 16}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mpq.Open("file0"); err != nil {
+		t.Error(err)
+	}
+	if _, err = mpq.Open("zeros"); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("Expected ErrLimitExceeded for the compression ratio, got:", err)
+	}
+
+	if DefaultLimits.MaxEntries == 4 {
+		t.Error("WithLimits must not change DefaultLimits.")
+	}
+}
+
+func TestLimits_HETTableSize(t *testing.T) {
+	data := addHETAndBET(t, (&testArchive{}).add("file", []byte("file"), fileFlagExists).build(t))
+
+	for _, size := range []uint64{1 << 40, 5, uint64(len(data))} {
+		corrupt := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(corrupt[92:100], size)
+		if _, err := OpenBytes(corrupt); err == nil {
+			t.Errorf("%d> Expected an error for the HET table size.", size)
+		}
+	}
+}
+
+// TestOpen_Corrupt truncates and corrupts every byte of an archive, which must
+// result in errors rather than panics.
+func TestOpen_Corrupt(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("stored", testData(100), fileFlagExists)
+	archive.add("sectors", testData(3000), fileFlagExists|fileFlagCompress|fileFlagSectorCRC)
+	archive.add("single", testData(300), fileFlagExists|fileFlagCompress|fileFlagSingleUnit)
+	data := addHETAndBET(t, addAttributes(archive).build(t))
+
+	readAll := func(mpq *MPQ) {
+		files, _ := mpq.Files()
+		for _, name := range files {
+			if reader, err := mpq.Open(name); err == nil {
+				ioutil.ReadAll(reader)
+			}
+		}
+	}
+
+	for n := 0; n < len(data); n++ {
+		if mpq, err := OpenBytes(data[:n]); err == nil {
+			readAll(mpq)
+		}
+	}
+
+	for i := 0; i < len(data); i++ {
+		for _, flip := range []byte{0x01, 0x80, 0xFF} {
+			corrupt := append([]byte(nil), data...)
+			corrupt[i] ^= flip
+			if mpq, err := OpenReader(bytes.NewReader(corrupt)); err == nil {
+				readAll(mpq)
+			}
+		}
+	}
+}
+
+// editExtTable decrypts the HET or BET table at the position stored at
+// header[field:], lets edit change it and encrypts it again.
+func editExtTable(data []byte, field int, key uint32, edit func(table []byte)) {
+	position := int(binary.LittleEndian.Uint64(data[field:]))
+	size := int(binary.LittleEndian.Uint32(data[position+8:]))
+	table := data[position+extTableHeaderSize : position+extTableHeaderSize+size]
+
+	decryptBlock(table, len(table), key)
+	edit(table)
+	encryptBlock(table, key)
+}
+
+func TestLimits_ExtTableArrays(t *testing.T) {
+	data := addHETAndBET(t, (&testArchive{}).add("file", []byte("file"), fileFlagExists).build(t))
+
+	tests := []struct {
+		name   string
+		field  int
+		key    uint32
+		edit   func(table []byte)
+		target error
+	}{
+		{"BET entries", 52, cryptKeyBlockTable, func(table []byte) {
+			binary.LittleEndian.PutUint32(table[4:8], 1<<24)
+			binary.LittleEndian.PutUint32(table[12:16], 320)
+		}, ErrLimitExceeded},
+		{"BET truncated", 52, cryptKeyBlockTable, func(table []byte) {
+			binary.LittleEndian.PutUint32(table[4:8], 1000)
+		}, errorBETTableBounds},
+		{"HET indexes truncated", 60, cryptKeyHashTable, func(table []byte) {
+			binary.LittleEndian.PutUint32(table[4:8], 1000)
+		}, errorHETTableBounds},
+	}
+
+	for _, test := range tests {
+		corrupt := append([]byte(nil), data...)
+		editExtTable(corrupt, test.field, test.key, test.edit)
+		if _, err := OpenBytes(corrupt); !errors.Is(err, test.target) {
+			t.Errorf("%s: Expected %v, got: %v", test.name, test.target, err)
+		}
+	}
+}
+
+func TestLimits_CompressionRatio(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("zeros", make([]byte, 8<<20), fileFlagExists|fileFlagCompress)
+	archive.add("single", make([]byte, 4<<20), fileFlagExists|fileFlagCompress|fileFlagSingleUnit)
+	data := archive.build(t)
+
+	// Each sector is compared to the sector size, not the file to its data.
+	mpq, err := OpenBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"zeros", "single"} {
+		if contents, err := mpq.readAll(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !bytes.Equal(contents, make([]byte, len(contents))) || len(contents) == 0 {
+			t.Errorf("%s: Wrong contents", name)
+		}
+	}
+
+	mpq, err = OpenBytes(data, WithLimits(Limits{MaxCompressionRatio: 16}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mpq.readAll("zeros"); !errors.Is(err, ErrLimitExceeded) {
+		t.Error("Expected ErrLimitExceeded for a sector, got:", err)
+	}
+}
@@ -0,0 +1,38 @@
+package mpq
+
+import (
+	"errors"
+)
+
+// errMmapUnsupported is returned by mmapFile where files can not be mapped.
+var errMmapUnsupported = errors.New("Memory mapping is not supported")
+
+// OpenMmap opens an MPQ file by mapping it into memory, which avoids a system
+// call per read and lets tables and files that are stored as is be read
+// without copying. Where mapping is not supported the file is opened with
+// Open instead. Readers returned by the archive must not be used after Close.
+func OpenMmap(filename string, opts ...Option) (*MPQ, error) {
+	data, err := mmapFile(filename)
+	if err == errMmapUnsupported {
+		return Open(filename, opts...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	m, err := OpenBytes(data, opts...)
+	if err != nil {
+		munmap(data)
+		return nil, err
+	}
+
+	m.closer = mapping(data)
+	m.name = filename
+	return m, nil
+}
+
+// mapping unmaps memory mapped data when it is closed.
+type mapping []byte
+
+func (mm mapping) Close() error {
+	return munmap(mm)
+}
@@ -0,0 +1,33 @@
+//go:build linux
+
+package mpq
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps a whole file into memory read only.
+func mmapFile(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	size := info.Size()
+	if size == 0 || int64(int(size)) != size {
+		return nil, errMmapUnsupported
+	}
+
+	return syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
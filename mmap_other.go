@@ -0,0 +1,11 @@
+//go:build !linux
+
+package mpq
+
+func mmapFile(filename string) ([]byte, error) {
+	return nil, errMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package mpq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpenMmap(t *testing.T) {
+	mpq, err := OpenMmap("Garden of Terror (72).StormReplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mpq.Close()
+
+	expected, err := Open("Garden of Terror (72).StormReplay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expected.Close()
+
+	files, err := mpq.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(expected.fileNames) {
+		t.Fatalf("Wrong number of files: %d", len(files))
+	}
+
+	for _, name := range files {
+		contents, err := readTestFile(mpq, name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		want, err := readTestFile(expected, name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(contents, want) {
+			t.Errorf("%s> Wrong contents.", name)
+		}
+	}
+}
+
+func TestOpenBytes(t *testing.T) {
+	data := testData(5000)
+	archive := (&testArchive{}).
+		add("plain", data, fileFlagExists).
+		add("compressed", data, fileFlagExists|fileFlagCompress).
+		build(t)
+
+	mpq, err := OpenBytes(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reading the same archive from two readers at once must not interfere.
+	plain, err := mpq.Open("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := mpq.Open("compressed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := make([]byte, 100)
+	if _, err = plain.Read(first); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data) {
+		t.Error("Wrong contents for compressed.")
+	}
+	rest, err = ioutil.ReadAll(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(first, rest...), data) {
+		t.Error("Wrong contents for plain.")
+	}
+
+	// Files stored as is are read directly from the given data.
+	file, err := mpq.FileInfo("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive[file.Position] ^= 0xFF
+	reader, err := mpq.Open("plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents[0] != data[0]^0xFF {
+		t.Error("Expected the contents to share memory with the archive.")
+	}
+}
+
+func readTestFile(mpq *MPQ, name string) ([]byte, error) {
+	file, err := mpq.FileInfo(name)
+	if err != nil {
+		return nil, err
+	}
+	return mpq.readFile(file)
+}
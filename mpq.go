@@ -4,7 +4,8 @@ Package mpq provides read-only access to an MPQ file.
 Although this package may seem
 complete and wonderful I assure you it is not. There are a great many MPQ files with protections
 in them (typically mangled by third party tools not related to the creators of MPQ files) that
-cannot be handled by this package.
+cannot be handled by this package. Opening with the Recover option tolerates the most common of
+these protections.
 
 Furthermore there are a number of strange special cases and legacy situations that can arise
 for MPQ files, and as such these special cases and odd MPQ files may also fail to load.
@@ -15,7 +16,7 @@ since they are non-trivial to port.
 What is here (in theory) works with all versions of unprotected MPQs even if the contained file contents
 can not be decompressed.
 
-The API is fairly straight forward. Call Open/OpenReader to get an MPQ file handle opened. MPQs contain
+The API is fairly straight forward. Call Open/OpenReader (or OpenMmap/OpenBytes/OpenStream) to get an MPQ file handle opened. MPQs contain
 file offsets so seeking is a necessity hence the references to ReadSeeker. Once opened you can list files
 with Files or open one known to exist with the Open on the mpq type. Although there is decompression
 and decryption happening inside the reader produced from open, it acts as any other reader.
@@ -44,7 +45,8 @@ package mpq
 
 import (
 	"bytes"
-	"errors"
+	"context"
+	"fmt"
 	"io"
 	"os"
 )
@@ -53,6 +55,12 @@ import (
 // and contained files.
 type MPQ struct {
 	reader   io.ReadSeeker
+	readerAt io.ReaderAt
+	// data is the whole stream when the archive was opened from memory.
+	data   []byte
+	size   int64
+	closer io.Closer
+
 	Header   *Header
 	UserData *UserData
 
@@ -62,115 +70,221 @@ type MPQ struct {
 	BlockTable   *BlockTable
 	HiBlockTable *HiBlockTable
 
+	Attributes *Attributes
+	// AttributesError is why the (attributes) file could not be read, the
+	// archive is opened without Attributes then.
+	AttributesError error
+
+	// Digests holds the result of checking the MD5 digests of a v4 archive.
+	Digests []DigestCheck
+	// StrongSignature is set if a strong signature follows the archive.
+	StrongSignature *StrongSignature
+
+	// name is the file the archive was opened from, if any.
+	name   string
 	offset int64
+	opts   *options
+
+	hetLookup  tableIndex
+	hashLookup tableIndex
+
+	warnings []Warning
 
 	fileNames []string
 	FileList  map[string]*File
 }
 
 // Open an MPQ File for reading.
-func Open(filename string) (*MPQ, error) {
+func Open(filename string, opts ...Option) (*MPQ, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return OpenReader(f)
+	m, err := OpenReader(f, opts...)
+	if err != nil {
+		f.Close()
+		return nil, withArchive(err, filename)
+	}
+
+	m.name = filename
+	return m, nil
 }
 
-// OpenReader opens a stream that contains an MPQ file for reading.
-func OpenReader(reader io.ReadSeeker) (*MPQ, error) {
-	var buffer [4]byte
+// OpenReader opens a stream that contains an MPQ file for reading. If the
+// stream is an io.ReaderAt, such as an *os.File, files are read with ReadAt
+// and can be read at the same time.
+func OpenReader(reader io.ReadSeeker, opts ...Option) (*MPQ, error) {
+	return openReader(context.Background(), reader, opts)
+}
 
-	m := &MPQ{reader: reader, FileList: make(map[string]*File)}
+func openReader(ctx context.Context, reader io.ReadSeeker, opts []Option) (*MPQ, error) {
+	m := &MPQ{reader: reader, FileList: make(map[string]*File), opts: newOptions(opts)}
+
+	if readerAt, ok := reader.(io.ReaderAt); ok {
+		m.readerAt = readerAt
+	} else {
+		m.readerAt = seekReaderAt{reader}
+	}
 
 	var err error
-	readHeader := false
-	for !readHeader {
-		if _, err = reader.Read(buffer[:]); err != nil {
-			return nil, err
-		}
+	if m.size, err = reader.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err = reader.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	if err = m.load(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// defaultStreamBuffer is how much of a stream OpenStream keeps in memory
+// unless WithStreamBuffer is used.
+const defaultStreamBuffer = 32 << 20
 
-		if bytes.Compare(buffer[:3], headerMPQ) == 0 {
-			if buffer[3] == headerArchive {
-				if err = m.readArchiveHeader(reader); err != nil {
-					return nil, err
-				}
-				readHeader = true
-				break
-			} else if buffer[3] == headerUserData {
-				if err = m.readUserData(reader, m.offset); err != nil {
-					return nil, err
-				}
-			}
+// OpenStream opens an MPQ file from a stream that can not seek, such as an
+// HTTP request body. The stream is read to its end: up to 32MB, or the size
+// set with WithStreamBuffer, is kept in memory and a longer stream is copied
+// to a temporary file that Close removes.
+func OpenStream(reader io.Reader, opts ...Option) (*MPQ, error) {
+	spooled, err := spool(reader, newOptions(opts).streamBuffer)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := OpenReader(spooled, opts...)
+	if err != nil {
+		if closer, ok := spooled.(io.Closer); ok {
+			closer.Close()
 		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// OpenBytes opens an MPQ file held in memory. Tables are read and files that
+// are stored as is are returned without copying data, so data must not be
+// changed while the archive is in use.
+func OpenBytes(data []byte, opts ...Option) (*MPQ, error) {
+	reader := bytes.NewReader(data)
+	m := &MPQ{
+		reader:   reader,
+		readerAt: reader,
+		data:     data,
+		size:     int64(len(data)),
+		FileList: make(map[string]*File),
+		opts:     newOptions(opts),
+	}
+
+	if err := m.load(context.Background()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-		m.offset += 512
-		reader.Seek(m.offset, 0)
+// load finds the archive header in the stream and reads the tables. It stops
+// between tables once ctx is done.
+func (m *MPQ) load(ctx context.Context) error {
+	var err error
+	if err = m.findHeader(); err != nil {
+		return m.formatError("header", 0, err)
 	}
 
-	if !readHeader {
-		return nil, errors.New("Could not find MPQ header.")
+	// Sector offsets are 32-bit so a sector must be smaller than 4GB.
+	if m.Header.BlockSize > 22 {
+		return m.formatError("header", 0, corruptError(fmt.Sprintf("Sector size is invalid: 512 << %d", m.Header.BlockSize)))
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if m.Header.HETTablePos != 0 {
-		hetOffset := m.offset + int64(m.Header.HETTablePos)
-		if _, err = reader.Seek(hetOffset, 0); err != nil {
-			return nil, err
-		}
-		if err = m.readHETTable(reader); err != nil {
-			return nil, err
+		if err = m.readHETTable(int64(m.Header.HETTablePos)); err != nil {
+			return m.formatError("HET table", int64(m.Header.HETTablePos), err)
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if m.Header.BETTablePos != 0 {
-		betOffset := m.offset + int64(m.Header.BETTablePos)
-		if _, err = reader.Seek(betOffset, 0); err != nil {
-			return nil, err
-		}
-		if err = m.readBETTable(reader); err != nil {
-			return nil, err
+		if err = m.readBETTable(int64(m.Header.BETTablePos)); err != nil {
+			return m.formatError("BET table", int64(m.Header.BETTablePos), err)
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if m.Header.HashTablePos != 0 || m.Header.HashTablePosHi != 0 {
-		pos := m.offset + ((int64(m.Header.HashTablePosHi) << 32) | int64(m.Header.HashTablePos))
-		if _, err = reader.Seek(pos, 0); err != nil {
-			return nil, err
-		}
-		if err = m.readHashTable(reader); err != nil {
-			return nil, err
+		pos := m.tablePosition(uint32(m.Header.HashTablePos), m.Header.HashTablePosHi)
+		if err = m.readHashTable(pos); err != nil {
+			return m.formatError("hash table", pos, err)
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if m.Header.BlockTablePos != 0 || m.Header.BlockTablePosHi != 0 {
-		pos := m.offset + ((int64(m.Header.BlockTablePosHi) << 32) | int64(m.Header.BlockTablePos))
-		if _, err = reader.Seek(pos, 0); err != nil {
-			return nil, err
-		}
-		if err = m.readBlockTable(reader); err != nil {
-			return nil, err
+		pos := m.tablePosition(uint32(m.Header.BlockTablePos), m.Header.BlockTablePosHi)
+		if err = m.readBlockTable(pos); err != nil {
+			return m.formatError("block table", pos, err)
 		}
 	}
 
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if m.Header.HiBlockTablePos != 0 {
-		pos := m.offset + int64(m.Header.HiBlockTablePos)
-		if _, err = reader.Seek(pos, 0); err != nil {
-			return nil, err
+		if err = m.readHiBlockTable(int64(m.Header.HiBlockTablePos)); err != nil {
+			return m.formatError("hi-block table", int64(m.Header.HiBlockTablePos), err)
 		}
-		if err = m.readHiBlockTable(reader); err != nil {
-			return nil, err
+	}
+
+	if err = m.checkTables(); err != nil {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if m.Header.FormatVersion >= mpqFormatVersion4 {
+		if err = m.checkDigests(); err != nil {
+			return m.formatError("digests", 0, err)
 		}
 	}
 
+	if err = m.findStrongSignature(); err != nil {
+		return m.formatError("strong signature", m.archiveSize(), err)
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+	if err = m.readAttributes(); err != nil && err != ErrFileNotFound {
+		// The (attributes) are optional, an archive opens without them.
+		m.AttributesError = err
+		if err = m.warn(WarnAttributes, "(attributes)", 0, "(attributes)", err.Error()); err != nil {
+			return err
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 	if err = m.buildFileList(); err != nil {
-		return nil, err
+		return m.formatError("(listfile)", 0, err)
 	}
 
-	return m, nil
+	return nil
 }
 
-// Files in the archive.
+// Files in the archive. Deletion markers are included, ListFiles tells them
+// apart.
 func (m *MPQ) Files() ([]string, error) {
 	if m.fileNames == nil {
 		if err := m.buildFileList(); err != nil {
@@ -184,8 +298,26 @@ func (m *MPQ) Files() ([]string, error) {
 	return files, nil
 }
 
+// ListFiles returns the files of Files, sorted by name. Deletion markers have
+// IsDeletionMarker set.
+func (m *MPQ) ListFiles() ([]*File, error) {
+	names, err := m.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]*File, len(names))
+	for i, name := range names {
+		files[i] = m.FileList[name]
+	}
+	return files, nil
+}
+
 // Close attempts to close the MPQ file handle if the given stream has a close.
 func (m *MPQ) Close() error {
+	if m.closer != nil {
+		return m.closer.Close()
+	}
 	if closer, ok := m.reader.(io.Closer); ok {
 		return closer.Close()
 	}
@@ -2,6 +2,10 @@ package mpq
 
 import (
 	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -114,3 +118,83 @@ func TestMPQHeader(t *testing.T) {
 		t.Errorf("\nExpected: % 02X\nGot     : % 02X", headermd5, m.Header.MPQHeaderMD5)
 	}
 }
+
+// shortReader returns at most one byte from each Read.
+type shortReader struct {
+	reader io.ReadSeeker
+}
+
+func (s shortReader) Read(buffer []byte) (int, error) {
+	if len(buffer) > 1 {
+		buffer = buffer[:1]
+	}
+	return s.reader.Read(buffer)
+}
+
+func (s shortReader) Seek(offset int64, whence int) (int64, error) {
+	return s.reader.Seek(offset, whence)
+}
+
+// testUserData creates a user data block that points headerOffset bytes ahead.
+func testUserData(size int, headerOffset uint32) []byte {
+	userData := make([]byte, size)
+	copy(userData, "MPQ\x1B")
+	binary.LittleEndian.PutUint32(userData[4:8], uint32(size-userDataHeaderSize))
+	binary.LittleEndian.PutUint32(userData[8:12], headerOffset)
+	binary.LittleEndian.PutUint32(userData[12:16], uint32(size-userDataHeaderSize))
+	return userData
+}
+
+func TestOpen_HeaderLocation(t *testing.T) {
+	contents := testData(3000)
+	archive := (&testArchive{}).add("file", contents, fileFlagExists|fileFlagCompress).build(t)
+
+	tests := []struct {
+		name     string
+		prefix   []byte
+		offset   int64
+		userData bool
+	}{
+		{"Aligned", make([]byte, 1024), 1024, false},
+		{"Unaligned", make([]byte, 100), 100, false},
+		{"UserData", append(make([]byte, 512), testUserData(0x30, 0x30)...), 512 + 0x30, true},
+		{"UnalignedUserData", append(make([]byte, 7), testUserData(0x25, 0x25)...), 7 + 0x25, true},
+		{"UserDataWrongOffset", append(testUserData(16, 0x1000), make([]byte, 496)...), 512, false},
+	}
+
+	for _, test := range tests {
+		data := append(append([]byte(nil), test.prefix...), archive...)
+
+		for _, reader := range []io.ReadSeeker{bytes.NewReader(data), shortReader{bytes.NewReader(data)}} {
+			mpq, err := OpenReader(reader)
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+
+			if mpq.offset != test.offset {
+				t.Errorf("%s: Header found at %d", test.name, mpq.offset)
+			}
+			if (mpq.UserData != nil) != test.userData {
+				t.Errorf("%s: Wrong user data: %+v", test.name, mpq.UserData)
+			}
+
+			file, err := mpq.Open("file")
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+				continue
+			}
+			if result, err := ioutil.ReadAll(file); err != nil || !bytes.Equal(result, contents) {
+				t.Errorf("%s: Wrong contents: %v", test.name, err)
+			}
+		}
+	}
+
+	data := append(make([]byte, 4096), archive...)
+	if _, err := OpenBytes(data, WithLimits(Limits{MaxHeaderOffset: 1024})); !errors.Is(err, ErrHeaderNotFound) {
+		t.Error("Expected ErrHeaderNotFound, got:", err)
+	}
+	if _, err := OpenBytes(data, WithLimits(Limits{MaxHeaderOffset: 4096})); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,57 @@
+package mpq
+
+import (
+	"io"
+)
+
+// nestedBufferSize is the size up to which a compressed nested archive is
+// decoded into memory, larger ones are written to a temporary file.
+const nestedBufferSize = 32 << 20
+
+// OpenArchive opens an archive that is stored as a file of this archive. A
+// file stored without compression or encryption is read in place, otherwise
+// it is decoded first. Closing the returned archive does not close this one.
+func (m *MPQ) OpenArchive(name string, opts ...Option) (*MPQ, error) {
+	file, err := m.FileInfo(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if isStoredPlain(file) && m.data != nil {
+		start := m.offset + int64(file.Position)
+		if start+int64(file.FileSize) > m.size {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return OpenBytes(m.data[start:start+int64(file.FileSize)], opts...)
+	}
+
+	var reader io.ReadSeeker
+	if isStoredPlain(file) {
+		reader = io.NewSectionReader(m.readerAt, m.offset+int64(file.Position), int64(file.FileSize))
+	} else {
+		contents, err := m.open(file)
+		if err != nil {
+			return nil, err
+		}
+		if reader, err = spool(contents, nestedBufferSize); err != nil {
+			return nil, err
+		}
+	}
+
+	child, err := OpenReader(reader, opts...)
+	if err != nil {
+		if closer, ok := reader.(io.Closer); ok {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	return child, nil
+}
+
+// isStoredPlain is true if the file's contents are stored as is.
+func isStoredPlain(file *File) bool {
+	const transformed = fileCompressedMask | fileFlagEncrypted | fileFlagPatchFile
+	return file.Flags&fileFlagExists != 0 && file.Flags&transformed == 0 &&
+		file.FileSize != 0 && file.CompressedSize == file.FileSize
+}
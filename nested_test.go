@@ -0,0 +1,60 @@
+package mpq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMPQ_OpenArchive(t *testing.T) {
+	inner := (&testArchive{}).
+		add("inner", testData(3000), fileFlagExists|fileFlagCompress).
+		build(t)
+
+	outer := (&testArchive{}).
+		add("plain.mpq", inner, fileFlagExists).
+		add("compressed.mpq", inner, fileFlagExists|fileFlagCompress).
+		add("single.mpq", inner, fileFlagExists|fileFlagCompress|fileFlagSingleUnit).
+		add("file", []byte("outer"), fileFlagExists).
+		build(t)
+
+	parent := openTestArchive(t, outer)
+
+	for _, name := range []string{"plain.mpq", "compressed.mpq", "single.mpq"} {
+		child, err := parent.OpenArchive(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		reader, err := child.Open("inner")
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		if !bytes.Equal(contents, testData(3000)) {
+			t.Errorf("%s> Wrong contents.", name)
+		}
+
+		if err = child.Close(); err != nil {
+			t.Error(name, err)
+		}
+	}
+
+	reader, err := parent.Open("file")
+	if err != nil {
+		t.Fatal("Parent should still be usable:", err)
+	}
+	if contents, err := ioutil.ReadAll(reader); err != nil || string(contents) != "outer" {
+		t.Errorf("Wrong contents: %s %v", contents, err)
+	}
+
+	if _, err = parent.OpenArchive("file"); err == nil {
+		t.Error("Expected an error for a file that is not an archive.")
+	}
+	if _, err = parent.OpenArchive("missing"); err != ErrFileNotFound {
+		t.Error("Expected ErrFileNotFound, got:", err)
+	}
+}
@@ -0,0 +1,48 @@
+package mpq
+
+// Option changes how an archive is opened.
+type Option func(*options)
+
+type options struct {
+	strictDigests bool
+	recover       bool
+	lenient       bool
+	strict        bool
+	limits        Limits
+	streamBuffer  int64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{limits: DefaultLimits, streamBuffer: defaultStreamBuffer}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// StrictDigests makes opening a v4 archive fail if the header or one of its
+// tables does not match the MD5 digest stored in the header.
+func StrictDigests() Option {
+	return func(o *options) {
+		o.strictDigests = true
+	}
+}
+
+// Recover makes opening tolerate the changes protectors make to archives the
+// way Storm does: a hash table size that is not a power of two, tables that
+// overlap or run past the end of the stream, table positions that wrap around
+// to below the header, block table entries past the end, fake headers in front
+// of the real one and a wrong archive size or format version in the header.
+func Recover() Option {
+	return func(o *options) {
+		o.recover = true
+	}
+}
+
+// WithStreamBuffer sets how much of a stream OpenStream keeps in memory before
+// it copies the stream to a temporary file instead.
+func WithStreamBuffer(size int64) Option {
+	return func(o *options) {
+		o.streamBuffer = size
+	}
+}
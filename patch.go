@@ -0,0 +1,387 @@
+package mpq
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	patchInfoSize   = 28
+	patchHeaderSize = 0x44
+	xfrmHeaderSize  = 0x0C
+	bsdiffSize      = 32
+)
+
+var (
+	headerPatch  = []byte("PTCH")
+	headerMD5    = []byte("MD5_")
+	headerXFRM   = []byte("XFRM")
+	headerBSDIFF = []byte("BSDIFF40")
+)
+
+// These are the patch types a PTCH file can use.
+const (
+	PatchTypeBSD0 = "BSD0"
+	PatchTypeCOPY = "COPY"
+)
+
+var (
+	// ErrPatchBaseMismatch occurs when the file a patch is applied to
+	// does not match the MD5 the patch expects.
+	ErrPatchBaseMismatch = errors.New("Patch does not apply to this file")
+	// ErrPatchResultMismatch occurs when the result of applying a patch
+	// does not match the MD5 the patch expects.
+	ErrPatchResultMismatch = errors.New("Patched file does not match its MD5")
+	// ErrPatchNoBase occurs when there is no file for a patch to apply to.
+	ErrPatchNoBase = errors.New("No base file for patch")
+
+	errorPatchBounds = corruptError("Patch ended unexpectedly")
+)
+
+// PatchMetadata is the contents of a (patch_metadata) file of a patch
+// archive. Its layout is not documented, lines of the form key=value are
+// collected in Values and the raw contents are kept in Data.
+type PatchMetadata struct {
+	Values map[string]string
+	Data   []byte
+}
+
+// PatchMetadata reads the (patch_metadata) file stored under prefix, such as
+// base\, in the archive. It returns ErrFileNotFound if there is none.
+func (m *MPQ) PatchMetadata(prefix string) (*PatchMetadata, error) {
+	if prefix != "" && !strings.HasSuffix(prefix, `\`) {
+		prefix += `\`
+	}
+
+	file, err := m.FileInfo(prefix + "(patch_metadata)")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := m.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePatchMetadata(data), nil
+}
+
+func parsePatchMetadata(data []byte) *PatchMetadata {
+	metadata := &PatchMetadata{Values: make(map[string]string), Data: data}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, '='); i > 0 {
+			metadata.Values[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return metadata
+}
+
+// DetectPatchPrefix finds the prefix the files of patch are stored under when
+// it patches base, the way Storm does. A patch without a base\(patch_metadata)
+// file uses none. Otherwise the locale of base is used, such as enUS\, if base
+// has a list of MD5s for it like enUS-md5.lst, and base\ if it has none.
+func DetectPatchPrefix(base, patch *MPQ) string {
+	if _, err := patch.FileInfo(`base\(patch_metadata)`); err != nil {
+		return ""
+	}
+
+	names, err := base.Files()
+	if err != nil {
+		return `base\`
+	}
+	for _, name := range names {
+		if i := len(name) - len("-md5.lst"); i > 0 && strings.EqualFold(name[i:], "-md5.lst") && isLocale(name[:i]) {
+			return name[:i] + `\`
+		}
+	}
+	return `base\`
+}
+
+// isLocale is true for locale names such as enUS.
+func isLocale(s string) bool {
+	return len(s) == 4 &&
+		'a' <= s[0] && s[0] <= 'z' && 'a' <= s[1] && s[1] <= 'z' &&
+		'A' <= s[2] && s[2] <= 'Z' && 'A' <= s[3] && s[3] <= 'Z'
+}
+
+// PatchInfo precedes the data of a file flagged as a patch file.
+type PatchInfo struct {
+	Length   int
+	Flags    uint32
+	DataSize int
+	MD5      []byte
+}
+
+// Patch is an incremental patch (a PTCH file) that transforms a file in a
+// lower priority archive into its new version.
+type Patch struct {
+	DataSize   int
+	SizeBefore int
+	SizeAfter  int
+
+	MD5Before []byte
+	MD5After  []byte
+
+	// Type is PatchTypeBSD0 or PatchTypeCOPY.
+	Type string
+	// Data is the payload of the XFRM block, RLE decoded for BSD0 patches.
+	Data []byte
+}
+
+// readPatchInfo reads the patch info in front of a patch file's data.
+func (m *MPQ) readPatchInfo(file *File) (*PatchInfo, error) {
+	buffer := make([]byte, patchInfoSize)
+	if err := m.readAt(buffer, int64(file.Position)); err != nil {
+		return nil, err
+	}
+
+	info := &PatchInfo{
+		Length:   int(binary.LittleEndian.Uint32(buffer[0:4])),
+		Flags:    binary.LittleEndian.Uint32(buffer[4:8]),
+		DataSize: int(binary.LittleEndian.Uint32(buffer[8:12])),
+		MD5:      append([]byte(nil), buffer[12:28]...),
+	}
+
+	if info.Length < patchInfoSize || uint64(info.Length) > file.CompressedSize {
+		return nil, corruptError("Patch info is corrupt")
+	}
+
+	return info, nil
+}
+
+// patchData returns a copy of a patch file that describes the PTCH data
+// after its patch info.
+func (m *MPQ) patchData(file *File) (*File, error) {
+	info, err := m.readPatchInfo(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data := *file
+	data.Position += uint64(info.Length)
+	data.CompressedSize -= uint64(info.Length)
+	data.FileSize = uint64(info.DataSize)
+	data.Flags &^= fileFlagPatchFile
+	return &data, nil
+}
+
+// readPatch reads and decodes the PTCH data of a patch file.
+func (m *MPQ) readPatch(file *File) (*Patch, error) {
+	data, err := m.readFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePatch(data)
+}
+
+// readPatchHeader reads the header of the PTCH data of a patch file, which
+// tells the size and MD5 of the patched file, without the rest of the data.
+func (m *MPQ) readPatchHeader(file *File) (*Patch, error) {
+	reader, err := m.open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, patchHeaderSize)
+	if _, err = io.ReadFull(reader, data); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, errorPatchBounds
+	} else if err != nil {
+		return nil, err
+	}
+
+	return parsePatchHeader(data)
+}
+
+// ParsePatch decodes a PTCH file.
+func ParsePatch(data []byte) (*Patch, error) {
+	patch, err := parsePatchHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	xfrmSize := int(binary.LittleEndian.Uint32(data[60:64]))
+	if xfrmSize < xfrmHeaderSize || 56+xfrmSize > len(data) {
+		return nil, errorPatchBounds
+	}
+	payload := data[patchHeaderSize : 56+xfrmSize]
+
+	switch patch.Type {
+	case PatchTypeCOPY:
+		patch.Data = append([]byte(nil), payload...)
+	case PatchTypeBSD0:
+		// A control byte of the RLE expands to at most 128 bytes.
+		size := patch.DataSize - patchHeaderSize
+		if size < 0 || size > len(payload)*128 {
+			return nil, errorPatchBounds
+		}
+		if len(payload) < size {
+			patch.Data = decompressRLE(payload, size)
+		} else {
+			patch.Data = append([]byte(nil), payload...)
+		}
+	default:
+		return nil, unsupportedError(fmt.Sprintf("Patch type %q not supported", patch.Type))
+	}
+
+	return patch, nil
+}
+
+// parsePatchHeader decodes the header of a PTCH file.
+func parsePatchHeader(data []byte) (*Patch, error) {
+	if len(data) < patchHeaderSize {
+		return nil, errorPatchBounds
+	}
+
+	if !bytes.Equal(data[0:4], headerPatch) {
+		return nil, corruptError(fmt.Sprintf("Patch header not found, got: %02X", data[0:4]))
+	}
+	if !bytes.Equal(data[16:20], headerMD5) {
+		return nil, corruptError(fmt.Sprintf("Patch MD5 block not found, got: %02X", data[16:20]))
+	}
+	if !bytes.Equal(data[56:60], headerXFRM) {
+		return nil, corruptError(fmt.Sprintf("Patch XFRM block not found, got: %02X", data[56:60]))
+	}
+
+	patch := &Patch{
+		DataSize:   int(binary.LittleEndian.Uint32(data[4:8])),
+		SizeBefore: int(binary.LittleEndian.Uint32(data[8:12])),
+		SizeAfter:  int(binary.LittleEndian.Uint32(data[12:16])),
+		MD5Before:  append([]byte(nil), data[24:40]...),
+		MD5After:   append([]byte(nil), data[40:56]...),
+		Type:       string(data[64:68]),
+	}
+
+	return patch, nil
+}
+
+// decompressRLE expands the run length encoding BSD0 payloads may use: after
+// a 4 byte size a byte with the high bit set is followed by that many + 1
+// literal bytes and any other byte skips that many + 1 zero bytes.
+func decompressRLE(src []byte, size int) []byte {
+	dest := make([]byte, size)
+	if len(src) < 4 {
+		return dest
+	}
+	src = src[4:]
+
+	out := 0
+	for len(src) > 0 && out < size {
+		control := src[0]
+		src = src[1:]
+
+		count := int(control&0x7F) + 1
+		if control&0x80 == 0 {
+			out += count
+			continue
+		}
+
+		for i := 0; i < count && len(src) > 0 && out < size; i++ {
+			dest[out] = src[0]
+			src = src[1:]
+			out++
+		}
+	}
+
+	return dest
+}
+
+// Apply the patch to the contents of the file it patches. The MD5 of base
+// and of the result are checked against the ones stored in the patch.
+func (p *Patch) Apply(base []byte) ([]byte, error) {
+	if sum := md5.Sum(base); !bytes.Equal(sum[:], p.MD5Before) {
+		return nil, ErrPatchBaseMismatch
+	}
+
+	var result []byte
+	var err error
+	switch p.Type {
+	case PatchTypeCOPY:
+		result = p.Data
+	case PatchTypeBSD0:
+		result, err = applyBSD0(base, p.Data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := md5.Sum(result); !bytes.Equal(sum[:], p.MD5After) {
+		return nil, ErrPatchResultMismatch
+	}
+
+	return result, nil
+}
+
+// applyBSD0 applies Blizzard's variant of a bsdiff patch, which uses little
+// endian 32-bit control values and a sign and magnitude seek.
+func applyBSD0(old, patch []byte) ([]byte, error) {
+	if len(patch) < bsdiffSize || !bytes.Equal(patch[0:8], headerBSDIFF) {
+		return nil, corruptError("BSDIFF40 header not found")
+	}
+
+	ctrlSize := binary.LittleEndian.Uint64(patch[8:16])
+	dataSize := binary.LittleEndian.Uint64(patch[16:24])
+	newSize := binary.LittleEndian.Uint64(patch[24:32])
+
+	rest := uint64(len(patch) - bsdiffSize)
+	if ctrlSize > rest || dataSize > rest-ctrlSize {
+		return nil, errorPatchBounds
+	}
+
+	ctrl := patch[bsdiffSize : bsdiffSize+ctrlSize]
+	data := patch[bsdiffSize+ctrlSize : bsdiffSize+ctrlSize+dataSize]
+	extra := patch[bsdiffSize+ctrlSize+dataSize:]
+
+	// Every byte of the result comes from either the diff or the extra data.
+	if newSize > dataSize+uint64(len(extra)) {
+		return nil, errorPatchBounds
+	}
+
+	result := make([]byte, newSize)
+	var newOffset, oldOffset uint64
+	for newOffset < newSize {
+		if len(ctrl) < 12 {
+			return nil, errorPatchBounds
+		}
+
+		add := uint64(binary.LittleEndian.Uint32(ctrl[0:4]))
+		mov := uint64(binary.LittleEndian.Uint32(ctrl[4:8]))
+		seek := binary.LittleEndian.Uint32(ctrl[8:12])
+		ctrl = ctrl[12:]
+
+		if newOffset+add > newSize || add > uint64(len(data)) {
+			return nil, errorPatchBounds
+		}
+		copy(result[newOffset:], data[:add])
+		data = data[add:]
+
+		for i := uint64(0); i < add; i++ {
+			if oldOffset < uint64(len(old)) {
+				result[newOffset] += old[oldOffset]
+			}
+			newOffset++
+			oldOffset++
+		}
+
+		if newOffset+mov > newSize || mov > uint64(len(extra)) {
+			return nil, errorPatchBounds
+		}
+		copy(result[newOffset:], extra[:mov])
+		extra = extra[mov:]
+		newOffset += mov
+
+		if seek&0x80000000 != 0 {
+			seek = 0x80000000 - seek
+		}
+		oldOffset += uint64(int64(int32(seek)))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,241 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// bsd0Ctrl is one control triple of a BSD0 patch.
+type bsd0Ctrl struct {
+	add, mov, seek int
+}
+
+// makeBSD0 creates the bsdiff payload that turns old into new using ctrls.
+func makeBSD0(old, new []byte, ctrls []bsd0Ctrl) []byte {
+	var ctrl, data, extra []byte
+	newOffset, oldOffset := 0, 0
+
+	for _, c := range ctrls {
+		var seek uint32
+		if c.seek < 0 {
+			seek = 0x80000000 | uint32(-c.seek)
+		} else {
+			seek = uint32(c.seek)
+		}
+
+		var triple [12]byte
+		binary.LittleEndian.PutUint32(triple[0:4], uint32(c.add))
+		binary.LittleEndian.PutUint32(triple[4:8], uint32(c.mov))
+		binary.LittleEndian.PutUint32(triple[8:12], seek)
+		ctrl = append(ctrl, triple[:]...)
+
+		for i := 0; i < c.add; i++ {
+			data = append(data, new[newOffset]-old[oldOffset])
+			newOffset++
+			oldOffset++
+		}
+		extra = append(extra, new[newOffset:newOffset+c.mov]...)
+		newOffset += c.mov
+		oldOffset += c.seek
+	}
+
+	header := make([]byte, bsdiffSize)
+	copy(header, headerBSDIFF)
+	binary.LittleEndian.PutUint64(header[8:16], uint64(len(ctrl)))
+	binary.LittleEndian.PutUint64(header[16:24], uint64(len(data)))
+	binary.LittleEndian.PutUint64(header[24:32], uint64(len(new)))
+
+	return append(append(append(header, ctrl...), data...), extra...)
+}
+
+// compressTestRLE encodes data the way decompressRLE expects.
+func compressTestRLE(data []byte) []byte {
+	out := make([]byte, 4)
+	binary.LittleEndian.PutUint32(out, uint32(len(data)))
+
+	for i := 0; i < len(data); {
+		j := i
+		if data[i] == 0 {
+			for j < len(data) && j-i < 128 && data[j] == 0 {
+				j++
+			}
+			out = append(out, byte(j-i-1))
+		} else {
+			for j < len(data) && j-i < 128 && data[j] != 0 {
+				j++
+			}
+			out = append(out, 0x80|byte(j-i-1))
+			out = append(out, data[i:j]...)
+		}
+		i = j
+	}
+
+	return out
+}
+
+// makePTCH wraps a payload in the PTCH, MD5_ and XFRM blocks.
+func makePTCH(patchType string, old, new, payload []byte, rle bool) []byte {
+	dataSize := patchHeaderSize + len(payload)
+	if rle {
+		payload = compressTestRLE(payload)
+	}
+
+	header := make([]byte, patchHeaderSize)
+	copy(header[0:4], headerPatch)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(dataSize))
+	binary.LittleEndian.PutUint32(header[8:12], uint32(len(old)))
+	binary.LittleEndian.PutUint32(header[12:16], uint32(len(new)))
+	copy(header[16:20], headerMD5)
+	binary.LittleEndian.PutUint32(header[20:24], 0x28)
+	before, after := md5.Sum(old), md5.Sum(new)
+	copy(header[24:40], before[:])
+	copy(header[40:56], after[:])
+	copy(header[56:60], headerXFRM)
+	binary.LittleEndian.PutUint32(header[60:64], uint32(xfrmHeaderSize+len(payload)))
+	copy(header[64:68], patchType)
+
+	return append(header, payload...)
+}
+
+var (
+	patchOld   = []byte("The quick brown fox jumps over the lazy dog")
+	patchNew   = []byte("The quick red fox jumps over the lazy dog again")
+	patchCtrls = []bsd0Ctrl{{10, 3, 5}, {5, 0, -2}, {20, 9, 0}}
+)
+
+func TestPatch_BSD0(t *testing.T) {
+	for _, rle := range []bool{false, true} {
+		ptch := makePTCH(PatchTypeBSD0, patchOld, patchNew, makeBSD0(patchOld, patchNew, patchCtrls), rle)
+
+		patch, err := ParsePatch(ptch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if patch.Type != PatchTypeBSD0 || patch.SizeBefore != len(patchOld) || patch.SizeAfter != len(patchNew) {
+			t.Errorf("Wrong patch header: %+v", patch)
+		}
+
+		result, err := patch.Apply(patchOld)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result, patchNew) {
+			t.Errorf("Wrong result: %s", result)
+		}
+
+		if _, err = patch.Apply(patchNew); err != ErrPatchBaseMismatch {
+			t.Error("Expected ErrPatchBaseMismatch, got:", err)
+		}
+	}
+}
+
+func TestPatch_COPY(t *testing.T) {
+	patch, err := ParsePatch(makePTCH(PatchTypeCOPY, patchOld, patchNew, patchNew, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := patch.Apply(patchOld)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, patchNew) {
+		t.Errorf("Wrong result: %s", result)
+	}
+
+	patch.MD5After[0] ^= 0xFF
+	if _, err = patch.Apply(patchOld); err != ErrPatchResultMismatch {
+		t.Error("Expected ErrPatchResultMismatch, got:", err)
+	}
+}
+
+func TestPatch_Parse(t *testing.T) {
+	ptch := makePTCH(PatchTypeCOPY, patchOld, patchNew, patchNew, false)
+
+	if _, err := ParsePatch(ptch[:patchHeaderSize-1]); err == nil {
+		t.Error("Expected an error for a short patch.")
+	}
+	if _, err := ParsePatch(ptch[:len(ptch)-1]); err == nil {
+		t.Error("Expected an error for a truncated XFRM block.")
+	}
+
+	copy(ptch[64:68], "ABCD")
+	if _, err := ParsePatch(ptch); err == nil {
+		t.Error("Expected an error for an unknown patch type.")
+	}
+}
+
+func TestPatch_ArchiveSet(t *testing.T) {
+	middle := []byte("The quick red fox jumps over the lazy dog")
+
+	base := (&testArchive{}).
+		add("file", patchOld, fileFlagExists|fileFlagCompress).
+		build(t)
+	patch1 := (&testArchive{}).
+		add("file", makePTCH(PatchTypeCOPY, patchOld, middle, middle, false), fileFlagExists|fileFlagCompress|fileFlagPatchFile).
+		add("orphan", makePTCH(PatchTypeCOPY, nil, middle, middle, false), fileFlagExists|fileFlagPatchFile).
+		build(t)
+	patch2 := (&testArchive{}).
+		add("file", makePTCH(PatchTypeBSD0, middle, patchNew, makeBSD0(middle, patchNew, []bsd0Ctrl{{41, 6, 0}}), true), fileFlagExists|fileFlagPatchFile).
+		build(t)
+
+	set := &ArchiveSet{}
+	set.Add(openTestArchive(t, base), 0)
+	set.Add(openTestArchive(t, patch1), 1)
+	set.Add(openTestArchive(t, patch2), 2)
+
+	reader, err := set.Open("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result, patchNew) {
+		t.Errorf("Wrong result: %s", result)
+	}
+
+	if _, err = set.Open("orphan"); err != ErrPatchNoBase {
+		t.Error("Expected ErrPatchNoBase, got:", err)
+	}
+
+	info, err := set.FileInfo("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsPatch {
+		t.Error("File should be a patch.")
+	}
+	if sum := md5.Sum(patchNew); info.FileSize != uint64(len(patchNew)) || !bytes.Equal(info.MD5, sum[:]) {
+		t.Errorf("File should describe the patched file, got %d bytes, MD5 % 02X", info.FileSize, info.MD5)
+	}
+}
+
+func TestPatch_ArchiveSetFiles(t *testing.T) {
+	base := (&testArchive{}).
+		add("file", patchOld, fileFlagExists).
+		build(t)
+	patch := (&testArchive{}).
+		add("file", []byte("not a patch"), fileFlagExists|fileFlagPatchFile).
+		build(t)
+
+	set := &ArchiveSet{}
+	set.Add(openTestArchive(t, base), 0)
+	set.Add(openTestArchive(t, patch), 1)
+
+	// Listing the files does not read the patch, so a broken one is listed.
+	files, err := set.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[1] != "file" {
+		t.Error("Wrong files:", files)
+	}
+	if _, err = set.Open("file"); err == nil {
+		t.Error("Expected an error for the broken patch.")
+	}
+}
@@ -0,0 +1,52 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+)
+
+// readAt reads len(buffer) bytes at the given position relative to the archive start.
+func (m *MPQ) readAt(buffer []byte, position int64) error {
+	n, err := m.readerAt.ReadAt(buffer, m.offset+position)
+	if n == len(buffer) {
+		return nil
+	}
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// section returns a reader for size bytes at the given position relative to
+// the archive start. Archives opened from memory return a slice of the data.
+func (m *MPQ) section(position, size int64) io.Reader {
+	start := m.offset + position
+	if m.data == nil {
+		return io.NewSectionReader(m.readerAt, start, size)
+	}
+
+	if start < 0 || start > m.size {
+		return bytes.NewReader(nil)
+	}
+	if size > m.size-start {
+		size = m.size - start
+	}
+	return bytes.NewReader(m.data[start : start+size])
+}
+
+// seekReaderAt reads from positions of a stream by seeking before each read.
+type seekReaderAt struct {
+	reader io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(buffer []byte, offset int64) (int, error) {
+	if _, err := s.reader.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.ReadFull(s.reader, buffer)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
@@ -0,0 +1,140 @@
+package mpq
+
+// Protectors alter archives in ways Storm does not care about but that make
+// them look corrupt. In recovery mode the archive is read the way Storm reads
+// it, correcting what can be corrected.
+
+// recovering is true if the archive is opened with Recover.
+func (m *MPQ) recovering() bool {
+	return m.opts != nil && m.opts.recover
+}
+
+// recoverHeader corrects the header fields Storm ignores: an unknown format
+// version or header size is read as version 1 and an archive size that does
+// not fit the stream is replaced by the size of the stream.
+func (m *MPQ) recoverHeader() {
+	h := m.Header
+	if h.FormatVersion > mpqFormatVersion4 || h.HeaderSize < 32 {
+		m.Header = &Header{
+			HeaderSize:     32,
+			Size:           h.Size,
+			FormatVersion:  mpqFormatVersion1,
+			BlockSize:      h.BlockSize,
+			HashTablePos:   h.HashTablePos,
+			BlockTablePos:  h.BlockTablePos,
+			HashTableSize:  h.HashTableSize,
+			BlockTableSize: h.BlockTableSize,
+		}
+		h = m.Header
+	}
+
+	size := m.size - m.offset
+	hashTableEnd := m.tablePosition(uint32(h.HashTablePos), h.HashTablePosHi) + int64(h.HashTableSize)*hashTableEntrySize
+	blockTableEnd := m.tablePosition(uint32(h.BlockTablePos), h.BlockTablePosHi) + int64(h.BlockTableSize)*blockTableEntrySize
+	if archiveSize := m.archiveSize(); archiveSize <= 0 || archiveSize > size ||
+		(hashTableEnd <= size && archiveSize < hashTableEnd) || (blockTableEnd <= size && archiveSize < blockTableEnd) {
+		h.Size = int(size)
+		if h.FormatVersion >= mpqFormatVersion3 {
+			h.ArchiveSize = uint64(size)
+		}
+	}
+}
+
+// plausibleHeader is false for headers whose tables can not be read, such as
+// the fake headers protectors put in front of the real one.
+func (m *MPQ) plausibleHeader() bool {
+	h := m.Header
+	if h.BlockSize > 22 {
+		return false
+	}
+	if h.HETTablePos != 0 && h.BETTablePos != 0 {
+		return true
+	}
+
+	for _, position := range []int64{
+		m.tablePosition(uint32(h.HashTablePos), h.HashTablePosHi),
+		m.tablePosition(uint32(h.BlockTablePos), h.BlockTablePosHi),
+	} {
+		if start := m.offset + position; position == 0 || start < 0 || start >= m.size {
+			return false
+		}
+	}
+	return true
+}
+
+// tablePosition combines the parts of a table position. Storm adds the 32-bit
+// positions of a table to the header offset in 32 bits, so in recovery mode a
+// position past the end of the stream wraps around, possibly to below the
+// header.
+func (m *MPQ) tablePosition(low uint32, high uint16) int64 {
+	position := int64(high)<<32 | int64(low)
+	if m.recovering() && high == 0 && m.offset+position >= m.size && m.offset <= 0xFFFFFFFF {
+		if wrapped := int64(uint32(m.offset) + low); wrapped < m.size {
+			return wrapped - m.offset
+		}
+	}
+	return position
+}
+
+// storedTable is true if a table of count entries is stored as is rather
+// than compressed.
+func storedTable(count, entrySize int, compressedSize uint64) bool {
+	return compressedSize == 0 || compressedSize >= uint64(count)*uint64(entrySize)
+}
+
+// recoverTableSize cuts a table that is stored as is down to the entries that
+// fit in the stream, for tables that run past its end or overlap other tables
+// because their size is too large.
+func (m *MPQ) recoverTableSize(position int64, count, entrySize int) int {
+	start := m.offset + position
+	if start < 0 || start > m.size {
+		return 0
+	}
+	if available := (m.size - start) / int64(entrySize); int64(count) > available {
+		count = int(available)
+	}
+	return count
+}
+
+// powerOfTwo rounds count up to a power of two.
+func powerOfTwo(count int) int {
+	size := 1
+	for size < count {
+		size <<= 1
+	}
+	return size
+}
+
+// recoverHashTable pads a hash table with free entries up to the power of two
+// Storm rounds its size up to.
+func recoverHashTable(table []byte, count int) []byte {
+	for size := powerOfTwo(count) * hashTableEntrySize; len(table) < size; {
+		table = append(table, 0xFF)
+	}
+	return table
+}
+
+// recoverBlockTable clears the block table entries of files that start past
+// the end of the stream and cuts the size of those that run past it.
+func (m *MPQ) recoverBlockTable() {
+	entries := m.BlockTable.Entries()
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Flags&fileFlagExists == 0 {
+			continue
+		}
+
+		start := m.offset + int64(entry.FilePosition)
+		if start >= m.size {
+			*entry = BlockTableEntry{}
+			continue
+		}
+
+		if available := m.size - start; int64(entry.CompressedSize) > available {
+			entry.CompressedSize = uint32(available)
+			if entry.Flags&fileCompressedMask == 0 && int64(entry.FileSize) > available {
+				entry.FileSize = uint32(available)
+			}
+		}
+	}
+}
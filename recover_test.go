@@ -0,0 +1,155 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// editBlockTable decrypts the block table of the test archive whose header is
+// at offset, lets edit change it and encrypts it again.
+func editBlockTable(data []byte, offset int, edit func(table []byte)) {
+	header := data[offset:]
+	position := offset + int(binary.LittleEndian.Uint32(header[20:24]))
+	table := data[position : position+int(binary.LittleEndian.Uint32(header[28:32]))*blockTableEntrySize]
+
+	decryptBlock(table, len(table), cryptKeyBlockTable)
+	edit(table)
+	encryptBlock(table, cryptKeyBlockTable)
+}
+
+// toV1 turns a test archive into a version 1 archive.
+func toV1(data []byte) []byte {
+	binary.LittleEndian.PutUint32(data[4:8], 32)
+	binary.LittleEndian.PutUint16(data[12:14], mpqFormatVersion1)
+	return data
+}
+
+func TestRecover(t *testing.T) {
+	contentsA, contentsB := testData(700), testData(1500)
+	build := func() []byte {
+		return toV1((&testArchive{}).
+			add("a", contentsA, fileFlagExists).
+			add("b", contentsB, fileFlagExists).
+			build(t))
+	}
+	prefixed := func(prefix []byte, archive []byte) []byte {
+		return append(append([]byte(nil), prefix...), archive...)
+	}
+
+	// Each test makes a changed archive that fails to open or to read "b"
+	// without Recover, unless fails is false.
+	tests := []struct {
+		name   string
+		change func() []byte
+		fails  bool
+	}{
+		{"Unchanged", build, false},
+		{"HashTableSize", func() []byte {
+			data := build()
+			binary.LittleEndian.PutUint32(data[24:28], 12)
+			return data
+		}, false},
+		{"BlockTableSize", func() []byte {
+			data := build()
+			binary.LittleEndian.PutUint32(data[28:32], 1000)
+			return data
+		}, true},
+		{"BlockPastEnd", func() []byte {
+			data := build()
+			editBlockTable(data, 0, func(table []byte) {
+				binary.LittleEndian.PutUint32(table[0:4], uint32(len(data)+100))
+				binary.LittleEndian.PutUint32(table[20:24], 1<<20)
+				binary.LittleEndian.PutUint32(table[24:28], 1<<20)
+			})
+			return data
+		}, true},
+		{"FakeHeader", func() []byte {
+			fake := make([]byte, 512)
+			copy(fake, headerMPQ)
+			fake[3] = headerArchive
+			binary.LittleEndian.PutUint32(fake[4:8], 32)
+			binary.LittleEndian.PutUint32(fake[16:20], 0xFFFFFF00)
+			binary.LittleEndian.PutUint32(fake[20:24], 0xFFFFFF00)
+			return prefixed(fake, build())
+		}, true},
+		{"ArchiveSize", func() []byte {
+			data := build()
+			binary.LittleEndian.PutUint32(data[8:12], 0xFFFFFFF0)
+			return data
+		}, false},
+		{"FormatVersion", func() []byte {
+			data := build()
+			binary.LittleEndian.PutUint16(data[12:14], 0x1234)
+			for i := 32; i < testHeaderSize; i++ {
+				data[i] = 0xFF
+			}
+			return data
+		}, true},
+		{"WrappedPosition", func() []byte {
+			data := build()
+			hashTable := data[binary.LittleEndian.Uint32(data[16:20]):]
+			prefix := make([]byte, 512)
+			copy(prefix, hashTable[:16*hashTableEntrySize])
+
+			data = prefixed(prefix, data)
+			binary.LittleEndian.PutUint32(data[512+16:512+20], uint32(0x100000000-512))
+			return data
+		}, true},
+	}
+
+	for _, test := range tests {
+		data := test.change()
+
+		if mpq, err := OpenBytes(data); err == nil {
+			if _, err = mpq.readAll("b"); (err != nil) != test.fails {
+				t.Errorf("%s: Unexpected result without Recover: %v", test.name, err)
+			}
+		} else if !test.fails {
+			t.Errorf("%s: %v", test.name, err)
+		}
+
+		mpq, err := OpenBytes(data, Recover())
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		files, err := mpq.Files()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if len(files) != 3 {
+			t.Errorf("%s: Wrong files: %v", test.name, files)
+		}
+
+		if mpq.archiveSize() != mpq.size-mpq.offset {
+			t.Errorf("%s: Wrong archive size: %d", test.name, mpq.archiveSize())
+		}
+
+		contents, err := mpq.readAll("b")
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !bytes.HasPrefix(contents, contentsB) {
+			t.Errorf("%s: Wrong contents of b", test.name)
+		}
+
+		contents, err = mpq.readAll("a")
+		if test.name == "BlockPastEnd" {
+			if err != ErrFileDeleted {
+				t.Errorf("%s: Expected ErrFileDeleted for a, got: %v", test.name, err)
+			}
+		} else if err != nil || !bytes.Equal(contents, contentsA) {
+			t.Errorf("%s: Wrong contents of a: %v", test.name, err)
+		}
+	}
+}
+
+// readAll reads the whole contents of a file.
+func (m *MPQ) readAll(name string) ([]byte, error) {
+	reader, err := m.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
@@ -0,0 +1,377 @@
+package mpq
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+)
+
+// sectorReader reads a file that is divided into sectors. Each sector is
+// read, checked and decompressed on its own as the reader advances.
+type sectorReader struct {
+	m    *MPQ
+	file *File
+	// ctx, if set, is checked before each sector is read.
+	ctx context.Context
+
+	// key of the first sector if the file is encrypted.
+	key       uint32
+	encrypted bool
+
+	sectorSize int
+	sectors    int
+	offsets    []uint32
+	checksums  []uint32
+
+	sector    int
+	buffer    []byte
+	remaining uint64
+}
+
+func (m *MPQ) sectorSize() int {
+	return 512 << m.Header.BlockSize
+}
+
+// newSectorReader creates a reader for a file stored in sectors. The key of an
+// encrypted file without a name is recovered from its sector offset table.
+func newSectorReader(m *MPQ, file *File, key uint32) (*sectorReader, error) {
+	s := &sectorReader{
+		m:          m,
+		file:       file,
+		key:        key,
+		encrypted:  file.Flags&fileFlagEncrypted != 0,
+		sectorSize: m.sectorSize(),
+		remaining:  file.FileSize,
+	}
+	s.sectors = int((file.FileSize + uint64(s.sectorSize) - 1) / uint64(s.sectorSize))
+
+	// Files that are not compressed are stored in sectors of exactly
+	// sectorSize bytes without an offset table in front of them.
+	if file.Flags&fileCompressedMask == 0 {
+		if s.encrypted && file.Name == "" {
+			return nil, unsupportedError("Cannot find the key of an encrypted file without a name")
+		}
+		return s, nil
+	}
+
+	count := s.sectors + 1
+	if file.Flags&fileFlagSectorCRC != 0 {
+		count++
+	}
+
+	if uint64(count)*4 > file.CompressedSize {
+		return nil, corruptError("Sector offset table is corrupt")
+	}
+
+	buffer := make([]byte, count*4)
+	if err := m.readAt(buffer, int64(file.Position)); err != nil {
+		return nil, err
+	}
+
+	if s.encrypted {
+		// The offset table is encrypted with the key of the sector before
+		// the first.
+		if file.Name == "" {
+			if err := s.recoverKey(buffer); err != nil {
+				return nil, err
+			}
+		}
+		decryptBlock(buffer, len(buffer), s.key-1)
+	}
+
+	s.offsets = make([]uint32, count)
+	for i := 0; i < count; i++ {
+		s.offsets[i] = binary.LittleEndian.Uint32(buffer[i*4:])
+	}
+
+	if s.offsets[0] != uint32(len(buffer)) {
+		return nil, corruptError("Sector offset table is corrupt")
+	}
+	for i := 1; i < count; i++ {
+		if s.offsets[i] < s.offsets[i-1] || uint64(s.offsets[i]) > file.CompressedSize {
+			return nil, corruptError("Sector offset table is corrupt")
+		}
+	}
+
+	if file.Flags&fileFlagSectorCRC != 0 {
+		if err := s.readChecksums(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// recoverKey finds the key of the file from its encrypted sector offset table,
+// whose first entry is the size of the table and whose second is at most a
+// sector further.
+func (s *sectorReader) recoverKey(table []byte) error {
+	size := uint32(len(table))
+	key, ok := recoverKey(table, size, func(second uint32) bool {
+		return second >= size && second-size <= uint32(s.sectorSize) && uint64(second) <= s.file.CompressedSize
+	})
+	if !ok {
+		return corruptError("Cannot find the key of the encrypted file")
+	}
+
+	s.key = key + 1
+	return nil
+}
+
+// sectorChecksum is the adler32 checksum of a sector as Storm calculates it,
+// with zlib's adler32 started at 0 instead of 1.
+func sectorChecksum(data []byte) uint32 {
+	const mod = 65521
+	// nmax is the most bytes that can be summed before b overflows.
+	const nmax = 5552
+
+	var a, b uint32
+	for len(data) > 0 {
+		n := len(data)
+		if n > nmax {
+			n = nmax
+		}
+		for _, c := range data[:n] {
+			a += uint32(c)
+			b += a
+		}
+		a, b = a%mod, b%mod
+		data = data[n:]
+	}
+	return b<<16 | a
+}
+
+// readChecksums reads the table of adler32 checksums stored after the last sector.
+func (s *sectorReader) readChecksums() error {
+	start, end := s.offsets[s.sectors], s.offsets[s.sectors+1]
+	if start == end {
+		return nil
+	}
+
+	raw := make([]byte, end-start)
+	if err := s.m.readAt(raw, int64(s.file.Position)+int64(start)); err != nil {
+		return err
+	}
+
+	buffer := make([]byte, s.sectors*4)
+	if err := decompress(buffer, raw); err != nil {
+		return err
+	}
+
+	s.checksums = make([]uint32, s.sectors)
+	for i := range s.checksums {
+		s.checksums[i] = binary.LittleEndian.Uint32(buffer[i*4:])
+	}
+	return nil
+}
+
+func (s *sectorReader) Read(buf []byte) (n int, err error) {
+	for n < len(buf) {
+		if len(s.buffer) == 0 {
+			if s.remaining == 0 {
+				return n, io.EOF
+			}
+			if err = s.readSector(); err != nil {
+				return n, err
+			}
+		}
+
+		copied := copy(buf[n:], s.buffer)
+		s.buffer = s.buffer[copied:]
+		n += copied
+	}
+
+	return n, nil
+}
+
+// readSector reads, verifies and decompresses the next sector into the buffer.
+func (s *sectorReader) readSector() error {
+	if s.ctx != nil {
+		if err := s.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	size := uint64(s.sectorSize)
+	if s.remaining < size {
+		size = s.remaining
+	}
+
+	var start, end uint64
+	if s.offsets != nil {
+		start, end = uint64(s.offsets[s.sector]), uint64(s.offsets[s.sector+1])
+	} else {
+		start = uint64(s.sector) * uint64(s.sectorSize)
+		end = start + size
+	}
+
+	raw := make([]byte, end-start)
+	if err := s.m.readAt(raw, int64(s.file.Position+start)); err != nil {
+		return err
+	}
+
+	// The words of a sector are encrypted, bytes after the last whole word
+	// are stored as is.
+	if s.encrypted {
+		decryptBlock(raw, len(raw), s.key+uint32(s.sector))
+	}
+
+	if s.checksums != nil && s.checksums[s.sector] != 0 {
+		if sectorChecksum(raw) != s.checksums[s.sector] {
+			return ErrSectorChecksum
+		}
+	}
+
+	sector := raw
+	if uint64(len(raw)) < size {
+		if err := s.m.limits().checkRatio("Sector", size, uint64(len(raw))); err != nil {
+			return err
+		}
+		sector = make([]byte, size)
+		if err := decompress(sector, raw); err != nil {
+			return err
+		}
+	} else if uint64(len(raw)) > size {
+		return corruptError("Sector is larger than expected")
+	}
+
+	s.buffer = sector
+	s.remaining -= size
+	s.sector++
+	return nil
+}
+
+// Sector describes how a sector of a file is stored.
+type Sector struct {
+	// Offset of the sector in the stream.
+	Offset         int64
+	CompressedSize uint64
+	Size           uint64
+	// Compression is the mask of the compression methods of the sector, 0
+	// if it is stored as is.
+	Compression byte
+	// CRC is the adler32 checksum stored for the sector, 0 if there is none.
+	CRC uint32
+}
+
+// Sectors describes how the sectors of a file are stored, as told by its
+// sector offset table. A single unit file is one sector. Only the tables and
+// the first byte of each compressed sector are read, nothing is decompressed.
+func (m *MPQ) Sectors(name string) ([]Sector, error) {
+	file, ok := m.FileList[name]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	sectors, err := m.sectors(file)
+	if err != nil && err != ErrFileDeleted {
+		return nil, &FormatError{Name: file.Name, Structure: "file", Offset: m.offset + int64(file.Position), Err: err}
+	}
+	return sectors, err
+}
+
+func (m *MPQ) sectors(file *File) ([]Sector, error) {
+	if file.Flags&fileFlagDelete != 0 || file.Flags&fileFlagExists == 0 {
+		return nil, ErrFileDeleted
+	}
+	if file.FileSize == 0 {
+		return nil, nil
+	}
+
+	var key uint32
+	if file.Flags&fileFlagEncrypted != 0 && file.Name != "" {
+		key = fileKey(file)
+	}
+
+	var err error
+	if file.Flags&fileFlagPatchFile != 0 {
+		if file, err = m.patchData(file); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = m.limits().checkFile(file); err != nil {
+		return nil, err
+	}
+	if err = m.checkRange(file.Name, int64(file.Position), file.CompressedSize); err != nil {
+		return nil, err
+	}
+
+	if file.Flags&fileFlagSingleUnit != 0 {
+		if file.Flags&fileFlagEncrypted != 0 && file.Name == "" {
+			return nil, unsupportedError("Cannot find the key of an encrypted single unit file without a name")
+		}
+		sector := Sector{
+			Offset:         m.offset + int64(file.Position),
+			CompressedSize: file.CompressedSize,
+			Size:           file.FileSize,
+		}
+		if sector.Compression, err = m.sectorCompression(file, sector, key); err != nil {
+			return nil, err
+		}
+		return []Sector{sector}, nil
+	}
+
+	s, err := newSectorReader(m, file, key)
+	if err != nil {
+		return nil, err
+	}
+
+	sectors := make([]Sector, s.sectors)
+	remaining := file.FileSize
+	for i := range sectors {
+		size := uint64(s.sectorSize)
+		if remaining < size {
+			size = remaining
+		}
+		remaining -= size
+
+		start, end := uint64(i)*uint64(s.sectorSize), uint64(i)*uint64(s.sectorSize)+size
+		if s.offsets != nil {
+			start, end = uint64(s.offsets[i]), uint64(s.offsets[i+1])
+		}
+
+		sector := Sector{
+			Offset:         m.offset + int64(file.Position+start),
+			CompressedSize: end - start,
+			Size:           size,
+		}
+		if s.checksums != nil {
+			sector.CRC = s.checksums[i]
+		}
+		if sector.Compression, err = m.sectorCompression(file, sector, s.key+uint32(i)); err != nil {
+			return nil, err
+		}
+		sectors[i] = sector
+	}
+
+	return sectors, nil
+}
+
+// sectorCompression reads the compression mask in front of a sector that is
+// smaller than its data. Imploded sectors have none, their mask is that of
+// PKWARE compression.
+func (m *MPQ) sectorCompression(file *File, sector Sector, key uint32) (byte, error) {
+	if sector.CompressedSize >= sector.Size {
+		return 0, nil
+	}
+	if file.Flags&fileFlagImplode != 0 {
+		return compressionPkware, nil
+	}
+	if file.Flags&fileFlagCompress == 0 || sector.CompressedSize == 0 {
+		return 0, nil
+	}
+
+	// Only whole words are encrypted.
+	word := make([]byte, 4)
+	if sector.CompressedSize < 4 {
+		word = word[:1]
+	}
+	if err := m.readAt(word, sector.Offset-m.offset); err != nil {
+		return 0, err
+	}
+	if file.Flags&fileFlagEncrypted != 0 {
+		decryptBlock(word, len(word), key)
+	}
+	return word[0], nil
+}
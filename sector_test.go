@@ -0,0 +1,269 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+// encryptFiles encrypts the files of a test archive that are flagged as
+// encrypted the way Storm does: the sector offset table with the file key - 1
+// and each sector with the file key + its index.
+func encryptFiles(t *testing.T, data []byte) []byte {
+	mpq := openTestArchive(t, data)
+	sectorSize := 512 << mpq.Header.BlockSize
+
+	for _, file := range mpq.FileList {
+		if file.Flags&fileFlagEncrypted == 0 {
+			continue
+		}
+		stored := data[file.Position : file.Position+file.CompressedSize]
+		key := fileKey(file)
+
+		if file.Flags&fileFlagSingleUnit != 0 {
+			encryptBlock(stored, key)
+			continue
+		}
+
+		if file.Flags&fileCompressedMask == 0 {
+			for i := 0; i*sectorSize < len(stored); i++ {
+				end := (i + 1) * sectorSize
+				if end > len(stored) {
+					end = len(stored)
+				}
+				encryptBlock(stored[i*sectorSize:end], key+uint32(i))
+			}
+			continue
+		}
+
+		count := int(binary.LittleEndian.Uint32(stored[0:4])) / 4
+		sectors := count - 1
+		if file.Flags&fileFlagSectorCRC != 0 {
+			sectors--
+		}
+		for i := 0; i < sectors; i++ {
+			start, end := binary.LittleEndian.Uint32(stored[i*4:]), binary.LittleEndian.Uint32(stored[i*4+4:])
+			encryptBlock(stored[start:end], key+uint32(i))
+		}
+		encryptBlock(stored[:count*4], key-1)
+	}
+
+	return data
+}
+
+func TestSectorReader(t *testing.T) {
+	compressed := testData(5000)
+	checksummed := testData(3000)
+	uncompressed := testData(1500)
+
+	archive := (&testArchive{blockSize: 1}).
+		add("compressed", compressed, fileFlagExists|fileFlagCompress).
+		add("checksummed", checksummed, fileFlagExists|fileFlagCompress|fileFlagSectorCRC).
+		add("uncompressed", uncompressed, fileFlagExists).
+		build(t)
+
+	mpq := openTestArchive(t, archive)
+
+	for name, expected := range map[string][]byte{
+		"compressed":   compressed,
+		"checksummed":  checksummed,
+		"uncompressed": uncompressed,
+	} {
+		reader, err := mpq.Open(name)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+
+		if !bytes.Equal(contents, expected) {
+			t.Errorf("%s> Contents differ", name)
+		}
+	}
+}
+
+func TestSectorReader_Checksum(t *testing.T) {
+	data := (&testArchive{blockSize: 1}).
+		add("corrupt", testData(3000), fileFlagExists|fileFlagCompress|fileFlagSectorCRC).
+		build(t)
+	corruptFile(t, data, "corrupt")
+
+	mpq := openTestArchive(t, data)
+
+	reader, err := mpq.Open("corrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ioutil.ReadAll(reader); err != ErrSectorChecksum {
+		t.Error("Expected a sector checksum error, got:", err)
+	}
+}
+
+func TestSectorReader_Encrypted(t *testing.T) {
+	const encrypted = fileFlagExists | fileFlagEncrypted
+	tests := []struct {
+		name  string
+		flags uint32
+		// anonymous is true if the file can be read without its name.
+		anonymous bool
+	}{
+		{`dir\compressed`, encrypted | fileFlagCompress, true},
+		{"checksummed", encrypted | fileFlagCompress | fileFlagSectorCRC, true},
+		{"fixkey", encrypted | fileFlagCompress | fileFlagFixKey, true},
+		{"uncompressed", encrypted | fileFlagFixKey, false},
+		{"single", encrypted | fileFlagCompress | fileFlagSingleUnit, false},
+		{"single stored", encrypted | fileFlagSingleUnit, false},
+	}
+
+	archive := &testArchive{}
+	contents := make(map[string][]byte)
+	for i, test := range tests {
+		contents[test.name] = testData(2000 + i*301)
+		archive.add(test.name, contents[test.name], test.flags)
+	}
+	mpq := openTestArchive(t, encryptFiles(t, archive.build(t)))
+
+	for _, test := range tests {
+		result, err := mpq.readAll(test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if !bytes.Equal(result, contents[test.name]) {
+			t.Errorf("%s: Contents differ", test.name)
+		}
+
+		file, err := mpq.FileInfo(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader, err := mpq.OpenIndex(file.BlockIndex)
+		if !test.anonymous {
+			if !errors.Is(err, ErrUnsupported) {
+				t.Errorf("%s: Expected ErrUnsupported without a name, got: %v", test.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if result, err = ioutil.ReadAll(reader); err != nil || !bytes.Equal(result, contents[test.name]) {
+			t.Errorf("%s: Contents differ without a name: %v", test.name, err)
+		}
+	}
+}
+
+func TestRecoverKey(t *testing.T) {
+	for _, key := range []uint32{0, 1, 0x12345678, 0xFFFFFFFF} {
+		block := make([]byte, 16)
+		binary.LittleEndian.PutUint32(block[0:4], 16)
+		binary.LittleEndian.PutUint32(block[4:8], 400)
+		encryptBlock(block, key)
+
+		recovered, ok := recoverKey(block, 16, func(second uint32) bool { return second == 400 })
+		if !ok || recovered != key {
+			t.Errorf("Key %08X: Recovered %08X %v", key, recovered, ok)
+		}
+	}
+}
+
+func TestMPQ_Sectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		flags   uint32
+		sectors int
+	}{
+		{"compressed", 5000, fileFlagExists | fileFlagCompress, 5},
+		{"checksummed", 3000, fileFlagExists | fileFlagCompress | fileFlagSectorCRC, 3},
+		{"encrypted", 2500, fileFlagExists | fileFlagCompress | fileFlagEncrypted | fileFlagSectorCRC, 3},
+		{"uncompressed", 1500, fileFlagExists, 2},
+		{"single", 4000, fileFlagExists | fileFlagCompress | fileFlagSingleUnit, 1},
+	}
+
+	archive := &testArchive{blockSize: 1}
+	for _, test := range tests {
+		archive.add(test.name, testData(test.size), test.flags)
+	}
+	data := encryptFiles(t, archive.build(t))
+	mpq := openTestArchive(t, data)
+
+	for _, test := range tests {
+		file, err := mpq.FileInfo(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sectors, err := mpq.Sectors(test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(sectors) != test.sectors {
+			t.Errorf("%s: Expected %d sectors, got: %d", test.name, test.sectors, len(sectors))
+			continue
+		}
+
+		var size uint64
+		for i, sector := range sectors {
+			size += sector.Size
+			if i > 0 && sector.Offset != sectors[i-1].Offset+int64(sectors[i-1].CompressedSize) {
+				t.Errorf("%s: Sector %d is not after the sector before it: %+v", test.name, i, sector)
+			}
+
+			compressed := sector.CompressedSize < sector.Size
+			if compressed && sector.Compression != compressionZlib || !compressed && sector.Compression != 0 {
+				t.Errorf("%s: Wrong compression of sector %d: %02X", test.name, i, sector.Compression)
+			}
+
+			var crc uint32
+			if file.HasSectorCRC {
+				raw := append([]byte(nil), data[sector.Offset:sector.Offset+int64(sector.CompressedSize)]...)
+				if file.IsEncrypted {
+					decryptBlock(raw, len(raw), fileKey(file)+uint32(i))
+				}
+				crc = sectorChecksum(raw)
+			}
+			if sector.CRC != crc {
+				t.Errorf("%s: Wrong CRC of sector %d: %08X", test.name, i, sector.CRC)
+			}
+		}
+		if file.IsCompressed && sectors[0].Compression != compressionZlib {
+			t.Errorf("%s: Expected the first sector to be compressed.", test.name)
+		}
+		if size != file.FileSize {
+			t.Errorf("%s: Sectors hold %d bytes, not %d", test.name, size, file.FileSize)
+		}
+	}
+
+	if _, err := mpq.Sectors("missing"); err != ErrFileNotFound {
+		t.Error("Expected ErrFileNotFound, got:", err)
+	}
+}
+
+func TestSectorChecksum(t *testing.T) {
+	long := make([]byte, 10000)
+	for i := range long {
+		long[i] = byte(i*7 + i/256)
+	}
+
+	// The expected values are zlib's adler32(0, data), as Storm calls it.
+	tests := []struct {
+		data     []byte
+		checksum uint32
+	}{
+		{nil, 0},
+		{[]byte("Wikipedia"), 0x11DD0397},
+		{long, 0xD1447355},
+	}
+
+	for _, test := range tests {
+		if checksum := sectorChecksum(test.data); checksum != test.checksum {
+			t.Errorf("%d bytes: Expected %08X, got: %08X", len(test.data), test.checksum, checksum)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding"
+	"encoding/pem"
+	"errors"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	weakSignatureFileSize = 8 + weakSignatureSize
+	weakSignatureSize     = 64
+
+	strongSignatureBlockSize = 4 + strongSignatureSize
+	strongSignatureSize      = 256
+)
+
+var headerStrongSignature = []byte("NGIS")
+
+// The well known public key Blizzard signs (signature) files with.
+const blizzardWeakPublicKey = `-----BEGIN PUBLIC KEY-----
+MFwwDQYJKoZIhvcNAQEBBQADSwAwSAJBAJJidwS/uILMBSO5DLGsBFknIXWWjQJe
+2kfdfEk3G/j66w4KkhZ1V61Rt4zLaMVCYpDun7FLwRjkMDSepO1q2DcCAwEAAQ==
+-----END PUBLIC KEY-----`
+
+// The well known public key Blizzard signs archives with a strong signature with.
+const blizzardStrongPublicKey = `-----BEGIN PUBLIC KEY-----
+MIIBIDANBgkqhkiG9w0BAQEFAAOCAQ0AMIIBCAKCAQEAsQZ+ziT2h8h+J/iMQpgd
+tH1HaJzOBE3agjU4yMPcrixaPOZoA4t8bwfey7qczfWywocYo3pleytFF+IuD4HD
+Fl9OXN1SFyupSgMx1EGZlgbFAomnbq9MQJyMqQtMhRAjFgg4TndS7YNb+JMSAEKp
+kXNqY28n/EVBHD5TsMuVCL579gIenbr61dI92DDEdy790IzIG0VKWLh/KOTcTJfm
+Ds/7HQTkGouVW+WUsfekuqNQo7ND9DBnhLjLjptxeFxmKKTZYsxNfQnUXoNtrSAg
+dUCTO7UVCAyNHGx/x2wXAN+BhS+BWlQ0y7Y0eeyOykCTBcnIX6FMGDJBEsZKRjy3
+xQIBAw==
+-----END PUBLIC KEY-----`
+
+// The well known public key Warcraft III maps are signed with. Their strong
+// signature covers the map followed by its upper cased file name.
+const warcraft3MapPublicKey = `-----BEGIN PUBLIC KEY-----
+MIIBIDANBgkqhkiG9w0BAQEFAAOCAQ0AMIIBCAKCAQEA1BwklUUQ3UvjizOBRoF5
+yHOVc1tvn8TH3vEkvQv9m3BgA0vHL5PSWYrRSYRFkn5Bq1rQJRSgjmLlzgxtGIZA
+/1dYeMAmgQihM3hb5ck1LzX8GTEKvYWtumqf5C8ll9sS7OBiQZ4y8NgYRL6SrAfQ
+ZUSwxjZ4IojHlpNhdq39fFaImCGh3GzO4gcx62NTNVLXmvxS+yzwVxHW1sP4xJxH
+p2JMEapo8ysaW3aaNH6eb/+iSIcJ4w25/vW6HGyw7kUKYQ/MBJQrbAdXCbSVkfAY
+ZOxbxyaNeAMkhEeLXmx0ThxMRJO+CtmIknzVhIh4gY9CwLUtjQnaB1BQJ3tKFvMH
+bAIBAw==
+-----END PUBLIC KEY-----`
+
+var (
+	// BlizzardWeakPublicKey is the public key used to check the (signature) file
+	// of archives signed by Blizzard.
+	BlizzardWeakPublicKey = mustParsePublicKey(blizzardWeakPublicKey)
+	// BlizzardStrongPublicKey is the public key used to check the strong
+	// signature of archives signed by Blizzard.
+	BlizzardStrongPublicKey = mustParsePublicKey(blizzardStrongPublicKey)
+	// Warcraft3MapPublicKey is the public key used to check the strong
+	// signature of Warcraft III maps.
+	Warcraft3MapPublicKey = mustParsePublicKey(warcraft3MapPublicKey)
+)
+
+// md5DigestInfo is the DER encoded prefix of a PKCS #1 v1.5 MD5 signature.
+var md5DigestInfo = []byte{0x30, 0x20, 0x30, 0x0c, 0x06, 0x08, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x02, 0x05, 0x05, 0x00, 0x04, 0x10}
+
+// SignatureStatus is the outcome of checking a digital signature.
+type SignatureStatus int
+
+// These are the possible outcomes of checking a signature.
+const (
+	// SignatureNone means the archive does not carry the signature.
+	SignatureNone SignatureStatus = iota
+	// SignatureValid means the signature matches the archive and key.
+	SignatureValid
+	// SignatureInvalid means the signature does not match the archive or key.
+	SignatureInvalid
+)
+
+func (s SignatureStatus) String() string {
+	switch s {
+	case SignatureNone:
+		return "unsigned"
+	case SignatureValid:
+		return "valid"
+	case SignatureInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
+
+// VerifyWeakSignature checks the (signature) file against BlizzardWeakPublicKey.
+func (m *MPQ) VerifyWeakSignature() (SignatureStatus, error) {
+	return m.VerifyWeakSignatureWithKey(BlizzardWeakPublicKey)
+}
+
+// VerifyWeakSignatureWithKey checks the 512-bit RSA signature stored in the
+// (signature) file against the MD5 of the archive, with the (signature) file
+// itself zeroed out.
+func (m *MPQ) VerifyWeakSignatureWithKey(key *rsa.PublicKey) (SignatureStatus, error) {
+	file, err := m.FileInfo("(signature)")
+	if err == ErrFileNotFound {
+		return SignatureNone, nil
+	} else if err != nil {
+		return SignatureNone, err
+	}
+
+	reader, err := m.open(file)
+	if err != nil {
+		return SignatureNone, err
+	}
+
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return SignatureNone, err
+	}
+	if len(contents) < weakSignatureFileSize {
+		return SignatureInvalid, nil
+	}
+
+	signature := reverse(contents[8:weakSignatureFileSize])
+
+	digest := md5.New()
+	exclude := [2]int64{int64(file.Position), int64(file.Position + file.CompressedSize)}
+	if err = m.hashArchive(digest, 0, m.archiveSize(), exclude); err != nil {
+		return SignatureNone, err
+	}
+
+	if !verifyPKCS1v15(key, md5DigestInfo, digest.Sum(nil), signature) {
+		return SignatureInvalid, nil
+	}
+	return SignatureValid, nil
+}
+
+// StrongSignature is the "NGIS" block that directly follows the archive data
+// at the end of the stream.
+type StrongSignature struct {
+	// Offset of the signature block in the stream.
+	Offset int64
+	// Start and End are the span of the stream covered by the signature.
+	Start int64
+	End   int64
+
+	// Signature as stored, little endian.
+	Signature []byte
+}
+
+// findStrongSignature looks for a strong signature the way Storm does: the
+// block must directly follow the archive and end the stream.
+func (m *MPQ) findStrongSignature() error {
+	offset := m.offset + m.archiveSize()
+	if offset+strongSignatureBlockSize != m.size {
+		return nil
+	}
+
+	block := make([]byte, strongSignatureBlockSize)
+	if err := m.readAt(block, offset-m.offset); err != nil {
+		return err
+	}
+	if !bytes.Equal(block[:4], headerStrongSignature) {
+		return nil
+	}
+
+	m.StrongSignature = &StrongSignature{
+		Offset:    offset,
+		Start:     m.offset,
+		End:       offset,
+		Signature: append([]byte(nil), block[4:]...),
+	}
+	return nil
+}
+
+// VerifyStrongSignature checks the strong signature against each of the well
+// known keys: BlizzardStrongPublicKey and Warcraft3MapPublicKey. The signature
+// is valid if any of them accepts it. For Warcraft3MapPublicKey the upper cased
+// name of the file the archive was opened from is tried as a tail as well.
+func (m *MPQ) VerifyStrongSignature(tails ...string) (SignatureStatus, error) {
+	if m.StrongSignature == nil {
+		return SignatureNone, nil
+	}
+
+	digest, err := m.strongSignatureDigest()
+	if err != nil {
+		return SignatureNone, err
+	}
+
+	if ok, err := m.matchStrongSignature(BlizzardStrongPublicKey, digest, tails); err != nil {
+		return SignatureNone, err
+	} else if ok {
+		return SignatureValid, nil
+	}
+	if m.name != "" {
+		tails = append(tails[:len(tails):len(tails)], strings.ToUpper(filepath.Base(m.name)))
+	}
+	if ok, err := m.matchStrongSignature(Warcraft3MapPublicKey, digest, tails); err != nil {
+		return SignatureNone, err
+	} else if ok {
+		return SignatureValid, nil
+	}
+	return SignatureInvalid, nil
+}
+
+// VerifyStrongSignatureWithKey checks the 2048-bit RSA strong signature
+// against the SHA-1 of the span it covers. Storm also accepts signatures made
+// over the span followed by a tail, the archive's upper cased file name or
+// "ARCHIVE". Signatures without a tail and with "ARCHIVE" are always tried,
+// other tails (such as the file name) may be given.
+func (m *MPQ) VerifyStrongSignatureWithKey(key *rsa.PublicKey, tails ...string) (SignatureStatus, error) {
+	if m.StrongSignature == nil {
+		return SignatureNone, nil
+	}
+
+	digest, err := m.strongSignatureDigest()
+	if err != nil {
+		return SignatureNone, err
+	}
+
+	ok, err := m.matchStrongSignature(key, digest, tails)
+	if err != nil {
+		return SignatureNone, err
+	} else if !ok {
+		return SignatureInvalid, nil
+	}
+	return SignatureValid, nil
+}
+
+// strongSignatureDigest is the SHA-1 of the span the strong signature covers.
+func (m *MPQ) strongSignatureDigest() (hash.Hash, error) {
+	digest := sha1.New()
+	start, end := m.StrongSignature.Start-m.offset, m.StrongSignature.End-m.offset
+	if err := m.hashArchive(digest, start, end, [2]int64{}); err != nil {
+		return nil, err
+	}
+	return digest, nil
+}
+
+// matchStrongSignature reports whether key signed digest, on its own, with
+// "ARCHIVE" or with one of tails appended.
+func (m *MPQ) matchStrongSignature(key *rsa.PublicKey, digest hash.Hash, tails []string) (bool, error) {
+	size := (key.N.BitLen() + 7) / 8
+	if size != strongSignatureSize {
+		return false, nil
+	}
+
+	decrypted := decryptSignature(key, reverse(m.StrongSignature.Signature))
+	if decrypted == nil {
+		return false, nil
+	}
+
+	for _, tail := range append([]string{"", "ARCHIVE"}, tails...) {
+		tailed := sha1.New()
+		if err := copyHash(tailed, digest); err != nil {
+			return false, err
+		}
+		tailed.Write([]byte(tail))
+
+		if bytes.Equal(decrypted, strongSignaturePadding(tailed.Sum(nil))) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// strongSignaturePadding is what a valid strong signature decrypts to, the
+// big endian form of the little endian block: digest, 0xBB..., 0x0B.
+func strongSignaturePadding(digest []byte) []byte {
+	padded := make([]byte, strongSignatureSize)
+	padded[0] = 0x0B
+	for i := 1; i < len(padded)-len(digest); i++ {
+		padded[i] = 0xBB
+	}
+	copy(padded[len(padded)-len(digest):], reverse(digest))
+	return padded
+}
+
+// copyHash sets dst to the state of src.
+func copyHash(dst, src hash.Hash) error {
+	state, err := src.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return dst.(encoding.BinaryUnmarshaler).UnmarshalBinary(state)
+}
+
+// archiveSize is the size of the archive starting at the header.
+func (m *MPQ) archiveSize() int64 {
+	if m.Header.FormatVersion >= mpqFormatVersion3 && m.Header.ArchiveSize != 0 {
+		return int64(m.Header.ArchiveSize)
+	}
+	return int64(m.Header.Size)
+}
+
+// hashArchive writes the archive bytes between start and end to w, with the
+// bytes inside the exclude range replaced by zeroes.
+func (m *MPQ) hashArchive(w io.Writer, start, end int64, exclude [2]int64) error {
+	const chunkSize = 0x10000
+	buffer := make([]byte, chunkSize)
+
+	for offset := start; offset < end; offset += chunkSize {
+		chunk := buffer
+		if end-offset < chunkSize {
+			chunk = buffer[:end-offset]
+		}
+
+		if err := m.readAt(chunk, offset); err != nil {
+			return err
+		}
+
+		for i := range chunk {
+			if pos := offset + int64(i); pos >= exclude[0] && pos < exclude[1] {
+				chunk[i] = 0
+			}
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyPKCS1v15 checks a PKCS #1 v1.5 signature. crypto/rsa refuses keys
+// smaller than 1024 bits which rules out the weak signature, so the check is
+// done here instead.
+func verifyPKCS1v15(key *rsa.PublicKey, prefix, hashed, signature []byte) bool {
+	size := (key.N.BitLen() + 7) / 8
+	if len(signature) != size || size < len(prefix)+len(hashed)+11 {
+		return false
+	}
+
+	em := decryptSignature(key, signature)
+	if em == nil {
+		return false
+	}
+
+	expected := make([]byte, size)
+	expected[1] = 0x01
+	for i := 2; i < size-len(prefix)-len(hashed)-1; i++ {
+		expected[i] = 0xFF
+	}
+	copy(expected[size-len(prefix)-len(hashed):], prefix)
+	copy(expected[size-len(hashed):], hashed)
+
+	return bytes.Equal(em, expected)
+}
+
+// decryptSignature applies the public key to a big endian signature and
+// returns the result padded to the size of the key.
+func decryptSignature(key *rsa.PublicKey, signature []byte) []byte {
+	s := new(big.Int).SetBytes(signature)
+	if s.Cmp(key.N) >= 0 {
+		return nil
+	}
+
+	em := s.Exp(s, big.NewInt(int64(key.E)), key.N).Bytes()
+
+	size := (key.N.BitLen() + 7) / 8
+	padded := make([]byte, size)
+	copy(padded[size-len(em):], em)
+	return padded
+}
+
+// reverse returns a reversed copy of b, signatures are stored little endian.
+func reverse(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i, v := range b {
+		r[len(b)-1-i] = v
+	}
+	return r
+}
+
+// ParsePublicKey parses a PEM encoded RSA public key for use with the
+// signature verification functions.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("Public key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func mustParsePublicKey(data string) *rsa.PublicKey {
+	key, err := ParsePublicKey([]byte(data))
+	if err != nil {
+		panic("mpq: " + err.Error())
+	}
+	return key
+}
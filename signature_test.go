@@ -0,0 +1,286 @@
+package mpq
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// generateTestKey creates an RSA key of the given size. rsa.GenerateKey
+// refuses the 512-bit keys the weak signature uses.
+func generateTestKey(t *testing.T, bits int) (*rsa.PublicKey, *big.Int) {
+	e := big.NewInt(65537)
+	one := big.NewInt(1)
+
+	for {
+		p, err := rand.Prime(rand.Reader, bits/2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := rand.Prime(rand.Reader, bits/2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := new(big.Int).Mul(p, q)
+		if n.BitLen() != bits {
+			continue
+		}
+
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil {
+			continue
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, d
+	}
+}
+
+// signRaw applies the private exponent to em and returns the signature little endian.
+func signRaw(key *rsa.PublicKey, d *big.Int, em []byte) []byte {
+	s := new(big.Int).Exp(new(big.Int).SetBytes(em), d, key.N).Bytes()
+	size := (key.N.BitLen() + 7) / 8
+	signature := make([]byte, size)
+	copy(signature[size-len(s):], s)
+	return reverse(signature)
+}
+
+func signedTestArchive(t *testing.T) ([]byte, *rsa.PublicKey) {
+	key, d := generateTestKey(t, weakSignatureSize*8)
+
+	archive := (&testArchive{}).
+		add("file", testData(5000), fileFlagExists|fileFlagCompress).
+		add("(signature)", make([]byte, weakSignatureFileSize), fileFlagExists).
+		build(t)
+
+	mpq := openTestArchive(t, archive)
+	file := mpq.FileList["(signature)"]
+	hashed := md5.Sum(archive)
+
+	em := make([]byte, weakSignatureSize)
+	em[1] = 0x01
+	for i := 2; i < weakSignatureSize-len(md5DigestInfo)-len(hashed)-1; i++ {
+		em[i] = 0xFF
+	}
+	copy(em[weakSignatureSize-len(md5DigestInfo)-len(hashed):], md5DigestInfo)
+	copy(em[weakSignatureSize-len(hashed):], hashed[:])
+
+	copy(archive[file.Position+8:], signRaw(key, d, em))
+	return archive, key
+}
+
+func TestWeakSignature(t *testing.T) {
+	archive, key := signedTestArchive(t)
+
+	mpq := openTestArchive(t, archive)
+	status, err := mpq.VerifyWeakSignatureWithKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureValid {
+		t.Error("Expected a valid signature, got:", status)
+	}
+
+	status, err = mpq.VerifyWeakSignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureInvalid {
+		t.Error("Expected the Blizzard key to reject the signature, got:", status)
+	}
+
+	file := mpq.FileList["file"]
+	archive[file.Position+10] ^= 0xFF
+
+	mpq = openTestArchive(t, archive)
+	status, err = mpq.VerifyWeakSignatureWithKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureInvalid {
+		t.Error("Expected an invalid signature, got:", status)
+	}
+}
+
+func TestWeakSignature_Unsigned(t *testing.T) {
+	setup()
+
+	status, err := m.VerifyWeakSignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureNone {
+		t.Error("Expected no signature, got:", status)
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	if BlizzardWeakPublicKey.N.BitLen() != 512 {
+		t.Error("Wrong key size:", BlizzardWeakPublicKey.N.BitLen())
+	}
+	if BlizzardStrongPublicKey.N.BitLen() != 2048 {
+		t.Error("Wrong key size:", BlizzardStrongPublicKey.N.BitLen())
+	}
+
+	if _, err := ParsePublicKey([]byte("not a key")); err == nil {
+		t.Error("Expected an error.")
+	}
+
+	if !bytes.Equal(reverse([]byte{1, 2, 3}), []byte{3, 2, 1}) {
+		t.Error("Reverse is wrong.")
+	}
+}
+
+func strongSignedTestArchive(t *testing.T, prefix, gap int, tail string) ([]byte, *rsa.PublicKey) {
+	key, d := generateTestKey(t, strongSignatureSize*8)
+
+	archive := append(make([]byte, prefix), (&testArchive{}).add("file", testData(5000), fileFlagExists|fileFlagCompress).build(t)...)
+	archive = append(archive, make([]byte, gap)...)
+
+	start := prefix
+	if gap != 0 {
+		start = 0
+	}
+
+	digest := sha1.New()
+	digest.Write(archive[start:])
+	digest.Write([]byte(tail))
+
+	archive = append(archive, headerStrongSignature...)
+	return append(archive, signRaw(key, d, strongSignaturePadding(digest.Sum(nil)))...), key
+}
+
+func TestStrongSignature(t *testing.T) {
+	tests := []struct {
+		prefix, gap int
+		tail        string
+		start       int64
+	}{
+		{0, 0, "", 0},
+		{512, 0, "ARCHIVE", 512},
+		{512, 0, "MAP.W3X", 512},
+		// A block that does not directly follow the archive is not a
+		// strong signature.
+		{512, 100, "MAP.W3X", -1},
+	}
+
+	for i, test := range tests {
+		archive, key := strongSignedTestArchive(t, test.prefix, test.gap, test.tail)
+
+		mpq := openTestArchive(t, archive)
+		signature := mpq.StrongSignature
+		if test.start < 0 {
+			if signature != nil {
+				t.Errorf("%d> Expected no strong signature, got one at %d", i, signature.Offset)
+			}
+			continue
+		}
+		if signature == nil {
+			t.Fatalf("%d> Strong signature was not found.", i)
+		}
+		if signature.Offset != int64(len(archive)-strongSignatureBlockSize) {
+			t.Errorf("%d> Wrong offset: %d", i, signature.Offset)
+		}
+		if signature.Start != test.start || signature.End != signature.Offset {
+			t.Errorf("%d> Wrong span: %d-%d", i, signature.Start, signature.End)
+		}
+
+		status, err := mpq.VerifyStrongSignatureWithKey(key, "MAP.W3X")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != SignatureValid {
+			t.Errorf("%d> Expected a valid signature, got: %v", i, status)
+		}
+
+		status, err = mpq.VerifyStrongSignature()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != SignatureInvalid {
+			t.Errorf("%d> Expected the Blizzard key to reject the signature, got: %v", i, status)
+		}
+
+		archive[test.prefix+testHeaderSize] ^= 0xFF
+		status, err = openTestArchive(t, archive).VerifyStrongSignatureWithKey(key, "MAP.W3X")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != SignatureInvalid {
+			t.Errorf("%d> Expected an invalid signature, got: %v", i, status)
+		}
+	}
+}
+
+func TestStrongSignature_Warcraft3Map(t *testing.T) {
+	archive, key := strongSignedTestArchive(t, 512, 0, "MAP.W3X")
+
+	defer func(key *rsa.PublicKey) { Warcraft3MapPublicKey = key }(Warcraft3MapPublicKey)
+	Warcraft3MapPublicKey = key
+
+	dir, err := ioutil.TempDir("", "mpq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "Map.w3x")
+	if err = ioutil.WriteFile(filename, archive, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mpq, err := Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mpq.Close()
+
+	// The upper cased file name is tried as a tail with the Warcraft III key.
+	status, err := mpq.VerifyStrongSignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureValid {
+		t.Error("Expected a valid signature, got:", status)
+	}
+
+	// Without a file name the tail has to be given.
+	status, err = openTestArchive(t, archive).VerifyStrongSignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureInvalid {
+		t.Error("Expected an invalid signature without the file name, got:", status)
+	}
+	status, err = openTestArchive(t, archive).VerifyStrongSignature("MAP.W3X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureValid {
+		t.Error("Expected a valid signature with the tail, got:", status)
+	}
+}
+
+func TestStrongSignature_Unsigned(t *testing.T) {
+	setup()
+
+	if m.StrongSignature != nil {
+		t.Error("There should be no strong signature.")
+	}
+
+	status, err := m.VerifyStrongSignature()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != SignatureNone {
+		t.Error("Expected no signature, got:", status)
+	}
+}
@@ -0,0 +1,53 @@
+package mpq
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// spool copies a stream so it can be seeked. Up to limit bytes are kept in
+// memory, a longer stream is written to a temporary file that is removed
+// when the returned reader is closed.
+func spool(reader io.Reader, limit int64) (io.ReadSeeker, error) {
+	buffer := &bytes.Buffer{}
+	n, err := io.CopyN(buffer, reader, limit+1)
+	if err == io.EOF || (err == nil && n <= limit) {
+		return bytes.NewReader(buffer.Bytes()), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile("", "mpq")
+	if err != nil {
+		return nil, err
+	}
+	temp := &tempFile{f}
+
+	if _, err = buffer.WriteTo(f); err == nil {
+		if _, err = io.Copy(f, reader); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		temp.Close()
+		return nil, err
+	}
+
+	return temp, nil
+}
+
+// tempFile is a temporary file that is removed when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+// Close and remove the file.
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	if removeErr := os.Remove(t.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
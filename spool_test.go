@@ -0,0 +1,94 @@
+package mpq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	data := testData(1000)
+
+	reader, err := spool(bytes.NewReader(data), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reader.(*bytes.Reader); !ok {
+		t.Errorf("Expected data within the limit to be kept in memory, got: %T", reader)
+	}
+
+	reader, err = spool(bytes.NewReader(data), 999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp, ok := reader.(*tempFile)
+	if !ok {
+		t.Fatalf("Expected a temporary file, got: %T", reader)
+	}
+
+	contents, err := ioutil.ReadAll(temp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(contents, data) {
+		t.Error("Wrong contents.")
+	}
+
+	if err = temp.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err = os.Stat(temp.Name()); !os.IsNotExist(err) {
+		t.Error("Temporary file was not removed:", err)
+	}
+}
+
+func TestOpenStream(t *testing.T) {
+	contents := testData(3000)
+	data := (&testArchive{}).add("file", contents, fileFlagExists|fileFlagCompress).build(t)
+
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(data)
+	writer.Close()
+
+	for _, bufferSize := range []int64{0, 1024} {
+		var opts []Option
+		if bufferSize != 0 {
+			opts = append(opts, WithStreamBuffer(bufferSize))
+		}
+
+		gzipReader, err := gzip.NewReader(bytes.NewReader(compressed.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mpq, err := OpenStream(struct{ io.Reader }{gzipReader}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		temp, isTemp := mpq.reader.(*tempFile)
+		if isTemp != (bufferSize != 0) {
+			t.Errorf("Buffer size %d: Wrong storage: %T", bufferSize, mpq.reader)
+		}
+
+		if result, err := readTestFile(mpq, "file"); err != nil || !bytes.Equal(result, contents) {
+			t.Errorf("Buffer size %d: Wrong contents: %v", bufferSize, err)
+		}
+
+		if err = mpq.Close(); err != nil {
+			t.Error(err)
+		}
+		if isTemp {
+			if _, err = os.Stat(temp.Name()); !os.IsNotExist(err) {
+				t.Error("Temporary file was not removed:", err)
+			}
+		}
+	}
+
+	if _, err := OpenStream(bytes.NewReader(make([]byte, 100))); err == nil {
+		t.Error("Expected an error for a stream without an archive.")
+	}
+}
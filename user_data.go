@@ -21,7 +21,7 @@ type UserData struct {
 
 func (m *MPQ) readUserData(r io.Reader, offset int64) error {
 	buffer := make([]byte, 12)
-	if _, err := r.Read(buffer); err != nil {
+	if _, err := io.ReadFull(r, buffer); err != nil {
 		return err
 	}
 
@@ -39,10 +39,5 @@ func (m *MPQ) readUserData(r io.Reader, offset int64) error {
 
 // OpenUserData returns a reader that can be used to read the user data.
 func (m *MPQ) OpenUserData() (io.Reader, error) {
-	_, err := m.reader.Seek(m.UserData.offset, 0)
-	if err != nil {
-		return nil, err
-	}
-
-	return io.LimitReader(m.reader, int64(m.UserData.MaxSize)), nil
+	return m.section(m.UserData.offset-m.offset, int64(m.UserData.MaxSize)), nil
 }
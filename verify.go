@@ -0,0 +1,177 @@
+package mpq
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// These errors are possible values of VerifyResult.Err.
+var (
+	// ErrCRC32Mismatch occurs when a file's contents do not match its CRC32 in (attributes).
+	ErrCRC32Mismatch = errors.New("CRC32 does not match (attributes)")
+	// ErrMD5Mismatch occurs when a file's contents do not match its MD5 in (attributes).
+	ErrMD5Mismatch = errors.New("MD5 does not match (attributes)")
+)
+
+// VerifyStatus is the outcome of verifying a single file.
+type VerifyStatus int
+
+// These are the possible outcomes of verifying a file.
+const (
+	// VerifyOK means the file was read and all checksums matched.
+	VerifyOK VerifyStatus = iota
+	// VerifyMismatch means the file was read but a checksum did not match.
+	VerifyMismatch
+	// VerifyUnreadable means the file could not be read from the archive.
+	VerifyUnreadable
+	// VerifyUnsupported means the file uses a compression or encryption
+	// method that this package cannot decode.
+	VerifyUnsupported
+)
+
+func (v VerifyStatus) String() string {
+	switch v {
+	case VerifyOK:
+		return "ok"
+	case VerifyMismatch:
+		return "mismatch"
+	case VerifyUnreadable:
+		return "unreadable"
+	case VerifyUnsupported:
+		return "unsupported"
+	}
+	return "unknown"
+}
+
+// VerifyResult describes the verification of a single file.
+type VerifyResult struct {
+	Name   string
+	Status VerifyStatus
+	// Err holds the reason the status is not VerifyOK.
+	Err error
+
+	// Which checksums were available and compared.
+	CheckedCRC32     bool
+	CheckedMD5       bool
+	CheckedSectorCRC bool
+	CheckedRawData   bool
+}
+
+// VerifyReport is the result of MPQ.Verify.
+type VerifyReport struct {
+	Files []VerifyResult
+}
+
+// OK is true if every file in the report verified successfully.
+func (v *VerifyReport) OK() bool {
+	return len(v.Failed()) == 0
+}
+
+// Failed returns the results of the files that did not verify successfully.
+func (v *VerifyReport) Failed() []VerifyResult {
+	var failed []VerifyResult
+	for _, result := range v.Files {
+		if result.Status != VerifyOK {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// Verify reads every file in the archive in full and checks it against the
+// CRC32 and MD5 stored in (attributes), any sector checksums and the raw data
+// MD5s of v4 archives. Deletion markers have no data and are skipped. Files
+// that fail to verify are reported in the returned VerifyReport, an error is
+// only returned if ctx is done before every file was verified, in which case
+// the report holds the files verified up to that point.
+func (m *MPQ) Verify(ctx context.Context) (*VerifyReport, error) {
+	files, err := m.Files()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{Files: make([]VerifyResult, 0, len(files))}
+	for _, name := range files {
+		if err = ctx.Err(); err != nil {
+			return report, err
+		}
+
+		file := m.FileList[name]
+		if file.IsDeletionMarker {
+			continue
+		}
+
+		result := m.verifyFile(ctx, file)
+		if err = ctx.Err(); err != nil && errors.Is(result.Err, err) {
+			return report, err
+		}
+
+		report.Files = append(report.Files, result)
+	}
+
+	return report, nil
+}
+
+// hasCRC32 is true if the (attributes) hold a CRC32 for the file, even if it
+// is 0. The (attributes) and (signature) can not describe themselves.
+func (m *MPQ) hasCRC32(file *File) bool {
+	if file.Name == "(attributes)" || file.Name == "(signature)" {
+		return false
+	}
+	return m.Attributes != nil && file.BlockIndex >= 0 && file.BlockIndex < len(m.Attributes.CRC32)
+}
+
+func (m *MPQ) verifyFile(ctx context.Context, file *File) VerifyResult {
+	result := VerifyResult{
+		Name:             file.Name,
+		CheckedCRC32:     m.hasCRC32(file),
+		CheckedMD5:       file.MD5 != nil,
+		CheckedSectorCRC: file.Flags&fileFlagSectorCRC != 0 && file.Flags&fileCompressedMask != 0,
+	}
+
+	checks, err := m.VerifyRawData(file)
+	if err != nil {
+		result.Status, result.Err = VerifyUnreadable, err
+		return result
+	}
+	result.CheckedRawData = checks != nil
+	for _, check := range checks {
+		if !check.OK() {
+			result.Status, result.Err = VerifyMismatch, ErrDigestMismatch
+			return result
+		}
+	}
+
+	crc := crc32.NewIEEE()
+	digest := md5.New()
+
+	reader, err := m.open(file)
+	if err == nil {
+		_, err = io.Copy(io.MultiWriter(crc, digest), &contextReader{ctx: ctx, reader: reader, name: file.Name})
+	}
+
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrUnsupported):
+		result.Status, result.Err = VerifyUnsupported, err
+		return result
+	default:
+		result.Status, result.Err = VerifyUnreadable, err
+		if errors.Is(err, ErrSectorChecksum) {
+			result.Status = VerifyMismatch
+		}
+		return result
+	}
+
+	if result.CheckedCRC32 && crc.Sum32() != file.CRC32 {
+		result.Status, result.Err = VerifyMismatch, ErrCRC32Mismatch
+	} else if result.CheckedMD5 && !bytes.Equal(digest.Sum(nil), file.MD5) {
+		result.Status, result.Err = VerifyMismatch, ErrMD5Mismatch
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+package mpq
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+)
+
+// corruptFile flips the last stored byte of a file of a test archive, after
+// its checksums were calculated.
+func corruptFile(t *testing.T, data []byte, name string) {
+	file, err := openTestArchive(t, data).FileInfo(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[file.Position+file.CompressedSize-1] ^= 0xFF
+}
+
+func TestVerify(t *testing.T) {
+	setup()
+
+	report, err := m.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(report.Files) != 14 {
+		t.Error("Wrong number of files verified:", len(report.Files))
+	}
+	for _, result := range report.Failed() {
+		t.Errorf("%s> %v: %v", result.Name, result.Status, result.Err)
+	}
+}
+
+func TestVerify_Failures(t *testing.T) {
+	archive := &testArchive{blockSize: 1}
+	archive.add("good", testData(3000), fileFlagExists|fileFlagCompress|fileFlagSectorCRC)
+	archive.add("sector", testData(3000), fileFlagExists|fileFlagCompress|fileFlagSectorCRC)
+	archive.add("crc", testData(3000), fileFlagExists)
+	archive.add("imploded", testData(100), fileFlagExists|fileFlagImplode)
+	data := addAttributes(archive).build(t)
+	corruptFile(t, data, "sector")
+	corruptFile(t, data, "crc")
+
+	mpq := openTestArchive(t, data)
+
+	report, err := mpq.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]VerifyStatus{
+		"(attributes)": VerifyOK,
+		"(listfile)":   VerifyOK,
+		"good":         VerifyOK,
+		"sector":       VerifyMismatch,
+		"crc":          VerifyMismatch,
+		"imploded":     VerifyUnsupported,
+	}
+
+	if len(report.Files) != len(expected) {
+		t.Error("Wrong number of files verified:", len(report.Files))
+	}
+	for _, result := range report.Files {
+		if result.Status != expected[result.Name] {
+			t.Errorf("%s> Wrong status: %v (%v)", result.Name, result.Status, result.Err)
+		}
+	}
+
+	if report.OK() {
+		t.Error("Report should not be OK.")
+	}
+	if len(report.Failed()) != 3 {
+		t.Error("Wrong number of failures:", len(report.Failed()))
+	}
+}
+
+func TestVerify_Cancel(t *testing.T) {
+	setup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := m.Verify(ctx)
+	if err != context.Canceled {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+	if report == nil || len(report.Files) != 0 {
+		t.Error("Expected an empty report.")
+	}
+}
+
+func TestVerify_ZeroCRC32(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("file", testData(300), fileFlagExists)
+	data := addAttributes(archive).build(t)
+
+	// A CRC32 of 0 in the (attributes) is checked like any other.
+	mpq := openTestArchive(t, data)
+	attributes, err := mpq.FileInfo("(attributes)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := mpq.FileInfo("file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[int(attributes.Position)+attributesHeaderSize+file.BlockIndex*4:], 0)
+
+	mpq = openTestArchive(t, data)
+	result := mpq.verifyFile(context.Background(), mpq.FileList["file"])
+	if !result.CheckedCRC32 || result.Err != ErrCRC32Mismatch {
+		t.Errorf("Expected a CRC32 mismatch, got: %v (%v)", result.Status, result.Err)
+	}
+}
+
+func TestVerify_DeletionMarker(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("file", testData(300), fileFlagExists)
+	archive.add("deleted", nil, fileFlagExists|fileFlagDelete)
+	mpq := openTestArchive(t, archive.build(t))
+
+	report, err := mpq.Verify(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Error("Report should be OK:", report.Failed())
+	}
+	for _, result := range report.Files {
+		if result.Name == "deleted" {
+			t.Error("Deletion markers should not be verified.")
+		}
+	}
+}
+
+// cancelAfterContext is done once its Err was called a number of times.
+type cancelAfterContext struct {
+	context.Context
+	calls int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.calls--; c.calls < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestVerify_CancelWhileReading(t *testing.T) {
+	archive := &testArchive{}
+	archive.add("file", testData(3000), fileFlagExists|fileFlagCompress)
+	mpq := openTestArchive(t, archive.build(t))
+
+	// The cancellation reaches Verify wrapped with the name of the file.
+	report, err := mpq.Verify(&cancelAfterContext{Context: context.Background(), calls: 1})
+	if err != context.Canceled {
+		t.Error("Expected context.Canceled, got:", err)
+	}
+	if report == nil || len(report.Files) != 0 {
+		t.Error("Expected an empty report.")
+	}
+}
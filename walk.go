@@ -0,0 +1,188 @@
+package mpq
+
+import (
+	"errors"
+	"fmt"
+)
+
+// EntryState is the state of a table entry that Walk visits.
+type EntryState int
+
+// These are the states of the entries Walk visits.
+const (
+	// EntryFree is a hash or HET table slot that has never been used.
+	EntryFree EntryState = iota
+	// EntryDeleted is a hash table slot whose file was removed.
+	EntryDeleted
+	// EntryUsed is a hash or HET table slot that refers to a block or BET
+	// table entry.
+	EntryUsed
+	// EntryInvalid is a hash or HET table slot that refers past the end of
+	// the block or BET table.
+	EntryInvalid
+	// EntryOrphan is a block or BET table entry that no slot refers to.
+	EntryOrphan
+)
+
+var entryStateNames = map[EntryState]string{
+	EntryFree:    "Free",
+	EntryDeleted: "Deleted",
+	EntryUsed:    "Used",
+	EntryInvalid: "Invalid",
+	EntryOrphan:  "Orphan",
+}
+
+func (e EntryState) String() string {
+	if name, ok := entryStateNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("EntryState(%d)", int(e))
+}
+
+// ErrStopWalk can be returned by the function given to Walk to stop without
+// an error.
+var ErrStopWalk = errors.New("Stop walk")
+
+// WalkFunc is called by Walk for each entry. structure is the table the entry
+// belongs to: "HET table" or "hash table" for slots and "BET table" or "block
+// table" for orphans. file holds what is known about the entry: free, deleted
+// and invalid slots only have HashIndex set and a BlockIndex of -1, orphans
+// have a HashIndex of -1. Name is set for the files of the (listfile).
+type WalkFunc func(structure string, state EntryState, file *File) error
+
+// Walk calls fn for every slot of the HET table in order and then for every
+// entry of the BET table that no slot refers to, which can hold data that is
+// hidden from the (listfile). It then does the same for the hash and block
+// tables, so an archive that has both pairs, such as a v4 archive, has each of
+// its tables walked. Walk stops at the first error fn returns and returns it,
+// unless it is ErrStopWalk.
+func (m *MPQ) Walk(fn WalkFunc) error {
+	err := m.walk(fn)
+	if err == ErrStopWalk {
+		return nil
+	}
+	return err
+}
+
+// walkTables describes a pair of tables for Walk.
+type walkTables struct {
+	slots, blocks string
+	slotCount     int
+	blockCount    int
+	state         func(slot int) (EntryState, error)
+	slotFile      func(slot int) (*File, error)
+	blockFile     func(index int) (*File, error)
+	find          func(name string) (*File, error)
+}
+
+func (m *MPQ) walk(fn WalkFunc) error {
+	var pairs []walkTables
+
+	if m.HETTable != nil && m.BETTable != nil {
+		pairs = append(pairs, walkTables{
+			slots:      "HET table",
+			blocks:     "BET table",
+			slotCount:  len(m.HETTable.Hashes),
+			blockCount: m.BETTable.EntryCount,
+			state: func(slot int) (EntryState, error) {
+				if m.HETTable.Hashes[slot] == 0 {
+					return EntryFree, nil
+				}
+				index, err := m.HETTable.Index(slot)
+				if err != nil {
+					return 0, err
+				}
+				if index >= m.BETTable.EntryCount {
+					return EntryInvalid, nil
+				}
+				return EntryUsed, nil
+			},
+			slotFile:  m.hetSlotFile,
+			blockFile: m.betFile,
+			find:      m.findFromHETAndBET,
+		})
+	}
+	if m.HashTable != nil && m.BlockTable != nil {
+		entries := m.HashTable.Entries()
+		pairs = append(pairs, walkTables{
+			slots:      "hash table",
+			blocks:     "block table",
+			slotCount:  len(entries),
+			blockCount: m.BlockTable.EntryCount,
+			state: func(slot int) (EntryState, error) {
+				switch index := entries[slot].BlockIndex; {
+				case index == hashTableEmpty:
+					return EntryFree, nil
+				case index == hashTableDeleted:
+					return EntryDeleted, nil
+				case int64(index) >= int64(m.BlockTable.EntryCount):
+					return EntryInvalid, nil
+				}
+				return EntryUsed, nil
+			},
+			slotFile:  m.hashSlotFile,
+			blockFile: m.blockFile,
+			find:      m.findFromHashAndBlock,
+		})
+	}
+	if len(pairs) == 0 {
+		return errors.New("HET, BET, Hash and Block tables are all unavailable")
+	}
+
+	for _, pair := range pairs {
+		if err := m.walkPair(pair, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MPQ) walkPair(pair walkTables, fn WalkFunc) error {
+	// The (listfile) names are looked up in this pair of tables, the ones in
+	// FileList came from one pair only.
+	names := make(map[int]string, len(m.FileList))
+	for name := range m.FileList {
+		if file, err := pair.find(name); err == nil {
+			names[file.HashIndex] = name
+		}
+	}
+
+	referenced := make([]bool, pair.blockCount)
+	for slot := 0; slot < pair.slotCount; slot++ {
+		s, err := pair.state(slot)
+		if err != nil {
+			return err
+		}
+
+		file := &File{BlockIndex: -1, HashIndex: slot}
+		if s == EntryUsed {
+			if file, err = pair.slotFile(slot); err != nil {
+				return err
+			}
+			referenced[file.BlockIndex] = true
+			m.applyAttributes(file)
+		}
+
+		file.Name = names[slot]
+		if err = fn(pair.slots, s, file); err != nil {
+			return err
+		}
+	}
+
+	for index, ok := range referenced {
+		if ok {
+			continue
+		}
+
+		file, err := pair.blockFile(index)
+		if err != nil {
+			return err
+		}
+		m.applyAttributes(file)
+		if err = fn(pair.blocks, EntryOrphan, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
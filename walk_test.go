@@ -0,0 +1,128 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	build := func() []byte {
+		return (&testArchive{}).
+			add("a", testData(700), fileFlagExists).
+			add("b", testData(1500), fileFlagExists).
+			build(t)
+	}
+
+	data := toV1(build())
+	file, err := openTestArchive(t, data).FileInfo("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting the hash table entry of a leaves its block entry orphaned.
+	deleteHash := func(data []byte) *MPQ {
+		position := int(binary.LittleEndian.Uint32(data[16:20]))
+		table := data[position : position+int(binary.LittleEndian.Uint32(data[24:28]))*hashTableEntrySize]
+		decryptBlock(table, len(table), cryptKeyHashTable)
+		binary.LittleEndian.PutUint32(table[file.HashIndex*hashTableEntrySize+12:], hashTableDeleted)
+		encryptBlock(table, cryptKeyHashTable)
+
+		mpq, err := OpenBytes(data, Lenient())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return mpq
+	}
+
+	deleted := deleteHash(data)
+	het := openTestArchive(t, addHETAndBET(t, build()))
+	hetDeleted := deleteHash(addHETAndBET(t, build()))
+
+	tests := []struct {
+		name   string
+		mpq    *MPQ
+		counts map[string]map[EntryState]int
+		named  map[string]int
+	}{
+		{
+			"Deleted", deleted,
+			map[string]map[EntryState]int{
+				"hash table":  {EntryUsed: 2, EntryDeleted: 1},
+				"block table": {EntryOrphan: 1},
+			},
+			map[string]int{"hash table": 2},
+		},
+		{
+			"HET", het,
+			map[string]map[EntryState]int{
+				"HET table":  {EntryUsed: 3},
+				"hash table": {EntryUsed: 3},
+			},
+			map[string]int{"HET table": 3, "hash table": 3},
+		},
+		{
+			"HET with a deleted hash entry", hetDeleted,
+			map[string]map[EntryState]int{
+				"HET table":   {EntryUsed: 3},
+				"hash table":  {EntryUsed: 2, EntryDeleted: 1},
+				"block table": {EntryOrphan: 1},
+			},
+			map[string]int{"HET table": 3, "hash table": 2},
+		},
+	}
+
+	for _, test := range tests {
+		counts := make(map[string]map[EntryState]int)
+		named := make(map[string]int)
+		err := test.mpq.Walk(func(structure string, state EntryState, f *File) error {
+			if counts[structure] == nil {
+				counts[structure] = make(map[EntryState]int)
+			}
+			counts[structure][state]++
+			if f.Name != "" {
+				named[structure]++
+			}
+
+			switch state {
+			case EntryOrphan:
+				if f.BlockIndex != file.BlockIndex || f.HashIndex != -1 || f.FileSize != 700 {
+					t.Errorf("%s: Wrong orphan: %+v", test.name, f)
+				}
+			case EntryDeleted:
+				if f.HashIndex != file.HashIndex || f.BlockIndex != -1 {
+					t.Errorf("%s: Wrong deleted slot: %+v", test.name, f)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for _, structure := range []string{"HET table", "BET table", "hash table", "block table"} {
+			for _, state := range []EntryState{EntryUsed, EntryDeleted, EntryInvalid, EntryOrphan} {
+				if got, want := counts[structure][state], test.counts[structure][state]; got != want {
+					t.Errorf("%s: Expected %d %s entries in the %s, got: %d", test.name, want, state, structure, got)
+				}
+			}
+			if named[structure] != test.named[structure] {
+				t.Errorf("%s: Expected %d names in the %s, got: %d", test.name, test.named[structure], structure, named[structure])
+			}
+		}
+		for structure := range test.counts {
+			if structure != "block table" && counts[structure][EntryFree] == 0 {
+				t.Errorf("%s: Expected free slots in the %s.", test.name, structure)
+			}
+		}
+	}
+
+	calls := 0
+	err = het.Walk(func(string, EntryState, *File) error {
+		calls++
+		return ErrStopWalk
+	})
+	if err != nil || calls != 1 {
+		t.Error("Walk should stop without an error:", calls, err)
+	}
+}
@@ -0,0 +1,220 @@
+package mpq
+
+import (
+	"fmt"
+)
+
+// WarningCode identifies the kind of anomaly a Warning describes.
+type WarningCode int
+
+// These are the anomalies that are found while opening an archive.
+const (
+	// WarnListfileName is a (listfile) entry that is not in the archive.
+	WarnListfileName WarningCode = iota + 1
+	// WarnNoListfile is a (listfile) that is missing or can not be read.
+	WarnNoListfile
+	// WarnAttributes is an (attributes) file that can not be read.
+	WarnAttributes
+	// WarnBlockIndex is a hash or HET table entry that refers to an entry
+	// past the end of the block or BET table.
+	WarnBlockIndex
+	// WarnBlockCount is a BET table with another amount of entries than the
+	// block table.
+	WarnBlockCount
+	// WarnHashTableSize is a hash table size that is not a power of two.
+	WarnHashTableSize
+	// WarnFilePastEnd is a block or BET table entry with data past the end
+	// of the stream.
+	WarnFilePastEnd
+	// WarnDigest is a header or table that does not match its MD5 digest.
+	WarnDigest
+)
+
+var warningCodeNames = map[WarningCode]string{
+	WarnListfileName:  "ListfileName",
+	WarnNoListfile:    "NoListfile",
+	WarnAttributes:    "Attributes",
+	WarnBlockIndex:    "BlockIndex",
+	WarnBlockCount:    "BlockCount",
+	WarnHashTableSize: "HashTableSize",
+	WarnFilePastEnd:   "FilePastEnd",
+	WarnDigest:        "Digest",
+}
+
+func (w WarningCode) String() string {
+	if name, ok := warningCodeNames[w]; ok {
+		return name
+	}
+	return fmt.Sprintf("WarningCode(%d)", int(w))
+}
+
+// Warning describes something odd about an archive that does not keep it from
+// being read.
+type Warning struct {
+	Code WarningCode
+	// Structure is the part of the archive, such as "hash table" or
+	// "(listfile)".
+	Structure string
+	// Offset of the structure, or of its entry, in the stream.
+	Offset int64
+	// Name of the file the warning is about, if any.
+	Name    string
+	Message string
+}
+
+func (w *Warning) Error() string {
+	if w.Name != "" {
+		return fmt.Sprintf("%s: %s: %s", w.Code, w.Name, w.Message)
+	}
+	return fmt.Sprintf("%s: %s", w.Code, w.Message)
+}
+
+// Lenient makes opening continue past a missing (listfile) and records it,
+// an unreadable (attributes) and other anomalies, see Warnings.
+func Lenient() Option {
+	return func(o *options) {
+		o.lenient = true
+	}
+}
+
+// Strict makes opening fail with a *FormatError that wraps a *Warning on the
+// first anomaly Lenient would record.
+func Strict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// Warnings returns the anomalies found while opening an archive with Lenient.
+func (m *MPQ) Warnings() []Warning {
+	warnings := make([]Warning, len(m.warnings))
+	copy(warnings, m.warnings)
+	return warnings
+}
+
+// lenient is true if the archive is opened with Lenient and not Strict.
+func (m *MPQ) lenient() bool {
+	return m.opts != nil && m.opts.lenient && !m.opts.strict
+}
+
+// warn records a warning at position, relative to the archive start. In strict
+// mode the warning is returned as an error instead.
+func (m *MPQ) warn(code WarningCode, structure string, position int64, name, message string) error {
+	if m.opts == nil || !m.opts.lenient && !m.opts.strict {
+		return nil
+	}
+
+	warning := Warning{Code: code, Structure: structure, Offset: m.offset + position, Name: name, Message: message}
+	if m.opts.strict {
+		return &FormatError{Name: name, Structure: structure, Offset: warning.Offset, Err: &warning}
+	}
+
+	m.warnings = append(m.warnings, warning)
+	return nil
+}
+
+// checkTables looks for entries of the tables that are out of range when the
+// archive is opened with Lenient or Strict.
+func (m *MPQ) checkTables() error {
+	if m.opts == nil || !m.opts.lenient && !m.opts.strict {
+		return nil
+	}
+
+	if m.HashTable != nil {
+		if err := m.checkHashTable(); err != nil {
+			return err
+		}
+	}
+	if m.HETTable != nil && m.BETTable != nil {
+		if err := m.checkHETTable(); err != nil {
+			return err
+		}
+	}
+
+	if m.BETTable != nil && m.BlockTable != nil && m.BETTable.EntryCount != m.BlockTable.EntryCount {
+		message := fmt.Sprintf("BET table has %d entries, block table has %d", m.BETTable.EntryCount, m.BlockTable.EntryCount)
+		if err := m.warn(WarnBlockCount, "BET table", int64(m.Header.BETTablePos), "", message); err != nil {
+			return err
+		}
+	}
+
+	return m.checkFilePositions()
+}
+
+func (m *MPQ) checkHashTable() error {
+	position := m.tablePosition(uint32(m.Header.HashTablePos), m.Header.HashTablePosHi)
+
+	if size := m.Header.HashTableSize; size&(size-1) != 0 {
+		if err := m.warn(WarnHashTableSize, "hash table", position, "", fmt.Sprintf("Hash table size %d is not a power of two", size)); err != nil {
+			return err
+		}
+	}
+
+	if m.BlockTable == nil {
+		return nil
+	}
+	for i, entry := range m.HashTable.Entries() {
+		if entry.BlockIndex >= hashTableDeleted || int64(entry.BlockIndex) < int64(m.BlockTable.EntryCount) {
+			continue
+		}
+		message := fmt.Sprintf("Hash table entry %d refers to block %d of %d", i, entry.BlockIndex, m.BlockTable.EntryCount)
+		if err := m.warn(WarnBlockIndex, "hash table", position+int64(i)*hashTableEntrySize, "", message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MPQ) checkHETTable() error {
+	for i, hash := range m.HETTable.Hashes {
+		if hash == 0 {
+			continue
+		}
+
+		index, err := m.HETTable.Index(i)
+		if err != nil {
+			return err
+		}
+		if index < m.BETTable.EntryCount {
+			continue
+		}
+
+		message := fmt.Sprintf("HET table entry %d refers to BET entry %d of %d", i, index, m.BETTable.EntryCount)
+		if err = m.warn(WarnBlockIndex, "HET table", int64(m.Header.HETTablePos), "", message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkFilePositions looks for files whose data runs past the end of the stream.
+func (m *MPQ) checkFilePositions() error {
+	check := func(structure string, position int64, i int, filePosition, size uint64, flags uint32) error {
+		if flags&fileFlagExists == 0 || m.checkRange(structure, int64(filePosition), size) == nil {
+			return nil
+		}
+		message := fmt.Sprintf("Entry %d has %d bytes at %d, past the end of the archive", i, size, filePosition)
+		return m.warn(WarnFilePastEnd, structure, position, "", message)
+	}
+
+	if m.HETTable != nil && m.BETTable != nil {
+		entries, err := m.BETTable.Entries()
+		if err != nil {
+			return err
+		}
+		for i, entry := range entries {
+			if err = check("BET table", int64(m.Header.BETTablePos), i, entry.FilePosition, entry.CompressedSize, entry.Flags); err != nil {
+				return err
+			}
+		}
+	} else if m.BlockTable != nil {
+		position := m.tablePosition(uint32(m.Header.BlockTablePos), m.Header.BlockTablePosHi)
+		for i, entry := range m.BlockTable.Entries() {
+			entryPosition := position + int64(i)*blockTableEntrySize
+			if err := check("block table", entryPosition, i, uint64(entry.FilePosition), uint64(entry.CompressedSize), entry.Flags); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	build := func(archive *testArchive) []byte {
+		return archive.
+			add("a", testData(700), fileFlagExists).
+			add("b", testData(1500), fileFlagExists).
+			build(t)
+	}
+
+	type warning struct {
+		code   WarningCode
+		offset int64
+		name   string
+	}
+
+	v1 := toV1(build(&testArchive{}))
+	blockTablePos := int64(binary.LittleEndian.Uint32(v1[20:24]))
+	hashTablePos := int64(binary.LittleEndian.Uint32(v1[16:20]))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		warnings []warning
+	}{
+		{"None", v1, nil},
+		{"Listfile", func() []byte {
+			archive := &testArchive{noListfile: true}
+			archive.add("(listfile)", []byte("a\r\nmissing\r\nb"), fileFlagExists)
+			return build(archive)
+		}(), []warning{{WarnListfileName, testHeaderSize, "missing"}}},
+		{"HashTableSize", func() []byte {
+			data := append([]byte(nil), v1...)
+			binary.LittleEndian.PutUint32(data[24:28], 12)
+			return data
+		}(), []warning{{WarnHashTableSize, hashTablePos, ""}}},
+		{"FilePastEnd", func() []byte {
+			data := append([]byte(nil), v1...)
+			editBlockTable(data, 0, func(table []byte) {
+				binary.LittleEndian.PutUint32(table[20:24], 1<<20)
+			})
+			return data
+		}(), []warning{{WarnFilePastEnd, blockTablePos + blockTableEntrySize, ""}}},
+		{"BlockCount", func() []byte {
+			data := addHETAndBET(t, build(&testArchive{}))
+			binary.LittleEndian.PutUint32(data[28:32], 2)
+			return data
+		}(), []warning{{WarnBlockIndex, -1, ""}, {WarnBlockCount, -1, ""}}},
+	}
+
+	for _, test := range tests {
+		mpq, err := OpenBytes(test.data, Lenient())
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		warnings := mpq.Warnings()
+		if len(warnings) != len(test.warnings) {
+			t.Errorf("%s: Wrong warnings: %+v", test.name, warnings)
+			continue
+		}
+		for i, expected := range test.warnings {
+			w := warnings[i]
+			if w.Code != expected.code || (expected.offset >= 0 && w.Offset != expected.offset) || w.Name != expected.name {
+				t.Errorf("%s: Wrong warning: %+v", test.name, w)
+			}
+		}
+
+		_, err = OpenBytes(test.data, Strict())
+		var warning *Warning
+		if len(test.warnings) == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+		} else if !errors.As(err, &warning) || warning.Code != test.warnings[0].code {
+			t.Errorf("%s: Expected a %s warning as error, got: %v", test.name, test.warnings[0].code, err)
+		}
+	}
+}
+
+func TestWarnings_Lenient(t *testing.T) {
+	// Hiding the (listfile) block makes the (listfile) unreadable and its
+	// hash table entry refer past the block table.
+	data := toV1((&testArchive{}).add("a", testData(700), fileFlagExists).build(t))
+	binary.LittleEndian.PutUint32(data[28:32], 1)
+
+	if _, err := OpenBytes(data); err == nil {
+		t.Error("Expected an error without Lenient.")
+	}
+
+	mpq, err := OpenBytes(data, Lenient())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []WarningCode
+	for _, warning := range mpq.Warnings() {
+		codes = append(codes, warning.Code)
+	}
+	if len(codes) != 2 || codes[0] != WarnBlockIndex || codes[1] != WarnNoListfile {
+		t.Error("Wrong warnings:", codes)
+	}
+
+	if _, err = mpq.FileInfo("a"); err != nil {
+		t.Error(err)
+	}
+	if WarnNoListfile.String() != "NoListfile" || WarningCode(100).String() != "WarningCode(100)" {
+		t.Error("Wrong names for warning codes.")
+	}
+}